@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/audit"
+	"github.com/robandpdx/gh-blob/internal/github"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// The audit sink and the actor are set up on the first record of a command
+// and shared by every record after it, so parallel uploads write through one
+// sink and the viewer is looked up once.
+var (
+	auditOnce  sync.Once
+	auditSink  audit.Sink
+	auditActor string
+	auditErr   error
+)
+
+func openAudit(cmd *cobra.Command) (audit.Sink, string, error) {
+	auditOnce.Do(func() {
+		target, _ := cmd.Flags().GetString("audit-log")
+		auditSink, auditErr = audit.NewSink(target)
+
+		actor, err := github.GetViewerLogin()
+		if err != nil {
+			ghlog.Logger.Warn("failed to resolve actor for audit records", zap.Error(err))
+		}
+		auditActor = actor
+	})
+	return auditSink, auditActor, auditErr
+}
+
+// recordAudit fills in the timestamp, actor and host of record and writes it
+// to the configured audit sink. Failing to audit is logged but never changes
+// the outcome of the command.
+func recordAudit(cmd *cobra.Command, record audit.Record) {
+	record.Timestamp = time.Now().UTC()
+	record.Host = defaultHost()
+
+	sink, actor, err := openAudit(cmd)
+	record.Actor = actor
+	if err == nil {
+		err = sink.Write(record)
+	}
+	if err != nil {
+		ghlog.Logger.Error("failed to write audit record",
+			zap.String("operation", record.Operation),
+			zap.Error(err))
+	}
+}
+
+func AuditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect the audit trail of blob uploads and deletes",
+	}
+	cmd.AddCommand(auditShow())
+	return cmd
+}
+
+func auditShow() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show audit records",
+		Long: `Show audit records written by upload and delete.
+Only file based audit logs can be queried.`,
+		Example: `gh blob audit show --org my-org --operation delete --since 168h`,
+		Args:    cobra.NoArgs,
+		RunE:    showAudit,
+	}
+	cmd.Flags().StringP("org", "o", "", "Only show records for this organization")
+	cmd.Flags().String("operation", "", "Only show this operation (upload, delete, soft-delete or restore)")
+	cmd.Flags().String("actor", "", "Only show records by this user")
+	cmd.Flags().String("outcome", "", "Only show this outcome (success or failure)")
+	cmd.Flags().String("blob", "", "Only show records for this blob ID, GUID or name")
+	cmd.Flags().String("since", "", "Only show records newer than this duration (e.g. 24h) or RFC 3339 time")
+	cmd.Flags().String("until", "", "Only show records older than this duration (e.g. 24h) or RFC 3339 time")
+	addOutputFlag(cmd, "table", "json")
+	// A profile org would hide the records of other orgs and of deletes by
+	// ID alone, which have no org
+	ignoreProfile(cmd, "org")
+	offline(cmd)
+	return cmd
+}
+
+func showAudit(cmd *cobra.Command, args []string) error {
+	target, _ := cmd.Flags().GetString("audit-log")
+	sink, err := audit.NewSink(target)
+	if err != nil {
+		return err
+	}
+	fileSink, ok := sink.(*audit.FileSink)
+	if !ok {
+		return fmt.Errorf("audit log %s is not a file and cannot be queried", target)
+	}
+
+	filter := audit.Filter{}
+	filter.Org, _ = cmd.Flags().GetString("org")
+	filter.Operation, _ = cmd.Flags().GetString("operation")
+	filter.Actor, _ = cmd.Flags().GetString("actor")
+	filter.Outcome, _ = cmd.Flags().GetString("outcome")
+	filter.Blob, _ = cmd.Flags().GetString("blob")
+
+	since, _ := cmd.Flags().GetString("since")
+	if filter.Since, err = parseTimeFlag(since); err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	until, _ := cmd.Flags().GetString("until")
+	if filter.Until, err = parseTimeFlag(until); err != nil {
+		return fmt.Errorf("invalid --until: %w", err)
+	}
+
+	records, err := audit.Read(fileSink.Path, filter)
+	if err != nil {
+		return err
+	}
+
+	output, _ := cmd.Flags().GetString("output")
+	out := cmd.OutOrStdout()
+	switch output {
+	case "json":
+		enc := json.NewEncoder(out)
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+	case "table":
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tACTOR\tORG\tOPERATION\tOUTCOME\tNAME\tID\tERROR")
+		for _, r := range records {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				r.Timestamp.Format(time.RFC3339), r.Actor, r.Org, r.Operation, r.Outcome, r.Name, r.BlobID, r.Error)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("invalid output format %q: must be table or json", output)
+	}
+	return nil
+}
+
+// parseTimeFlag accepts either a duration, meaning that long ago, or an
+// absolute RFC 3339 timestamp. An empty value yields the zero time.
+func parseTimeFlag(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/robandpdx/gh-blob/internal/github"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// expandArchivePaths expands glob patterns in the --archive-file-path values
+// and checks every file exists. A pattern matching nothing is an error, as is
+// a directory. Duplicates are dropped, keeping the first occurrence.
+func expandArchivePaths(patterns []string) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %s", pattern)
+			}
+		}
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("file does not exist: %s", path)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			if info.IsDir() {
+				return nil, fmt.Errorf("%s is a directory, not an archive", path)
+			}
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no archive to upload")
+	}
+	return paths, nil
+}
+
+// uploadResult is the outcome of uploading one of several archives.
+type uploadResult struct {
+	Path     string
+	Response *github.UploadArchiveResponse
+	Err      error
+}
+
+// uploadArchives uploads each file with up to concurrency uploads at once
+// and returns the results in the order of paths. Archives not yet started
+// when ctx is cancelled are skipped.
+func uploadArchives(ctx context.Context, cmd *cobra.Command, input github.UploadArchiveInput, paths []string, concurrency int, namer *blobNamer) []uploadResult {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ghlog.Logger.Info("Uploading archives",
+		zap.Int("count", len(paths)),
+		zap.Int("concurrency", concurrency))
+
+	results := make([]uploadResult, len(paths))
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, concurrency)
+	)
+	for i, path := range paths {
+		wg.Add(1)
+		go func(i int, path string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i].Path = path
+			if err := ctx.Err(); err != nil {
+				results[i].Err = fmt.Errorf("not started: %w", err)
+				return
+			}
+			results[i].Response, results[i].Err = uploadArchiveFile(ctx, cmd, input, path, namer)
+		}(i, path)
+	}
+	wg.Wait()
+	return results
+}
+
+// printUploadResults writes a table mapping each file to its blob.
+func printUploadResults(w io.Writer, results []uploadResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tSTATUS\tID\tURI")
+	for _, result := range results {
+		if result.Response == nil {
+			fmt.Fprintf(tw, "%s\tfailed\t-\t-\n", result.Path)
+			continue
+		}
+		if result.Err != nil {
+			// Uploaded, but replacing the previous archive failed
+			fmt.Fprintf(tw, "%s\tfailed\t%s\t%s\n", result.Path, result.Response.NodeID, result.Response.URI)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\tuploaded\t%s\t%s\n", result.Path, result.Response.NodeID, result.Response.URI)
+	}
+	return tw.Flush()
+}
+
+// uploadResultsError summarizes the failed uploads, or returns nil if every
+// archive was uploaded.
+func uploadResultsError(results []uploadResult) error {
+	var errs []error
+	for _, result := range results {
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", result.Path, result.Err))
+		}
+	}
+	if len(errs) == 0 {
+		ghlog.Logger.Info("Uploaded all archives", zap.Int("count", len(results)))
+		return nil
+	}
+	return fmt.Errorf("failed to upload %d of %d archives:\n%w", len(errs), len(results), errors.Join(errs...))
+}
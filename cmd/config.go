@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robandpdx/gh-blob/internal/config"
+	"github.com/robandpdx/gh-blob/internal/transport"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"go.uber.org/zap"
+)
+
+// ApplyProfile fills in every flag of cmd that was not given on the command
+// line from the selected config profile, so flags override profile values
+// which override the flag defaults.
+func ApplyProfile(cmd *cobra.Command) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	explicit, _ := cmd.Flags().GetString("profile")
+	name := cfg.ProfileName(explicit)
+	profile, err := cfg.Profile(name)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range config.Keys {
+		value := profile.Get(key)
+		flag := cmd.Flags().Lookup(key)
+		if value == "" || flag == nil || flag.Changed || excludedByChangedFlag(cmd, flag) || ignoresProfile(flag) {
+			continue
+		}
+		if !allowedValue(flag, value) {
+			ghlog.Logger.Debug("Ignoring config profile value not supported by this command",
+				zap.String("profile", name),
+				zap.String("key", key),
+				zap.String("value", value))
+			continue
+		}
+		if err := cmd.Flags().Set(key, value); err != nil {
+			return fmt.Errorf("invalid value %q for %s in profile %q: %v", value, key, name, err)
+		}
+		ghlog.Logger.Debug("Using value from config profile",
+			zap.String("profile", name),
+			zap.String("key", key))
+	}
+	return nil
+}
+
+// ConfigureLogger rebuilds the logger from the --log-* flags once they have
+// been parsed and the config profile has been applied.
+func ConfigureLogger(cmd *cobra.Command) error {
+	level, _ := cmd.Flags().GetString("log-level")
+	format, _ := cmd.Flags().GetString("log-format")
+	file, _ := cmd.Flags().GetString("log-file")
+
+	return ghlog.Configure(ghlog.Options{
+		Level:  level,
+		Format: format,
+		File:   file,
+	})
+}
+
+// ConfigureTransport sets up the proxy and TLS settings shared by every
+// GitHub client from the --ca-bundle, --client-cert, --client-key and
+// --insecure-skip-verify flags.
+func ConfigureTransport(cmd *cobra.Command) error {
+	opts := transport.Options{}
+	opts.CABundle, _ = cmd.Flags().GetString("ca-bundle")
+	opts.ClientCert, _ = cmd.Flags().GetString("client-cert")
+	opts.ClientKey, _ = cmd.Flags().GetString("client-key")
+	opts.InsecureSkipVerify, _ = cmd.Flags().GetBool("insecure-skip-verify")
+
+	if opts.InsecureSkipVerify {
+		ghlog.Logger.Warn("TLS certificate verification is DISABLED by --insecure-skip-verify. " +
+			"Connections to GitHub can be intercepted and your token stolen; use --ca-bundle instead.")
+	}
+	return transport.Configure(opts)
+}
+
+// allowedValuesAnnotation lists the values a flag accepts, for flags such as
+// --output whose valid values differ between commands.
+const allowedValuesAnnotation = "gh-blob_allowed_values"
+
+// addOutputFlag adds an --output flag accepting formats, the first being the
+// default. A profile output that the command does not support is ignored.
+func addOutputFlag(cmd *cobra.Command, formats ...string) {
+	usage := formats[0]
+	if len(formats) > 1 {
+		usage = strings.Join(formats[:len(formats)-1], ", ") + " or " + formats[len(formats)-1]
+	}
+	cmd.Flags().String("output", formats[0], "Output format: "+usage)
+	_ = cmd.Flags().SetAnnotation("output", allowedValuesAnnotation, formats)
+}
+
+// allowedValue reports whether flag accepts value, which is always the case
+// for flags without a list of allowed values.
+func allowedValue(flag *pflag.Flag, value string) bool {
+	allowed, ok := flag.Annotations[allowedValuesAnnotation]
+	if !ok {
+		return true
+	}
+	for _, a := range allowed {
+		if a == value {
+			return true
+		}
+	}
+	return false
+}
+
+// noProfileAnnotation marks a flag that shares its name with a profile key
+// but must only be set on the command line, such as a filter.
+const noProfileAnnotation = "gh-blob_no_profile"
+
+// ignoreProfile keeps the profile from filling in the named flag of cmd.
+func ignoreProfile(cmd *cobra.Command, name string) {
+	_ = cmd.Flags().SetAnnotation(name, noProfileAnnotation, []string{"true"})
+}
+
+func ignoresProfile(flag *pflag.Flag) bool {
+	_, ok := flag.Annotations[noProfileAnnotation]
+	return ok
+}
+
+// excludedByChangedFlag reports whether flag is mutually exclusive with a flag
+// that was given on the command line, e.g. a profile org when --enterprise is
+// used.
+func excludedByChangedFlag(cmd *cobra.Command, flag *pflag.Flag) bool {
+	for _, group := range flag.Annotations["cobra_annotation_mutually_exclusive"] {
+		for _, name := range strings.Fields(group) {
+			if other := cmd.Flags().Lookup(name); other != nil && other != flag && other.Changed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage gh-blob configuration profiles",
+		Long: `Manage gh-blob configuration profiles.
+Profiles are read from ~/.config/gh-blob/config.yml and overridden by a
+repo-local .gh-blob.yml. Supported keys: ` + strings.Join(config.Keys, ", ") + `.`,
+		// Managing profiles must not require the selected profile to exist
+		// yet, so skip the root command's profile resolution.
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return ConfigureLogger(cmd)
+		},
+	}
+
+	cmd.AddCommand(configGet(), configSet(), configList())
+	return cmd
+}
+
+func configGet() *cobra.Command {
+	return &cobra.Command{
+		Use:     "get <key>",
+		Short:   "Print the value of a key in the selected profile",
+		Example: `gh blob config get org --profile prod`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+			explicit, _ := cmd.Flags().GetString("profile")
+			profile, err := cfg.Profile(cfg.ProfileName(explicit))
+			if err != nil {
+				return err
+			}
+			value := profile.Get(args[0])
+			if value == "" {
+				return fmt.Errorf("key %q is not set", args[0])
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), value)
+			return nil
+		},
+	}
+}
+
+func configSet() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set <key> <value>",
+		Short: "Set a key in the selected profile",
+		Example: `gh blob config set org my-org --profile prod
+gh blob config set part-size 200MiB --local`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			local, _ := cmd.Flags().GetBool("local")
+
+			path := config.LocalConfigName
+			if !local {
+				var err error
+				path, err = config.GlobalPath()
+				if err != nil {
+					return err
+				}
+			} else if found := config.LocalPath(); found != "" {
+				path = found
+			}
+
+			cfg, err := config.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			explicit, _ := cmd.Flags().GetString("profile")
+			name := cfg.ProfileName(explicit)
+			profile, ok := cfg.Profiles[name]
+			if !ok {
+				profile = &config.Profile{}
+				cfg.Profiles[name] = profile
+			}
+			if err := profile.Set(args[0], args[1]); err != nil {
+				return err
+			}
+
+			if err := config.WriteFile(path, cfg); err != nil {
+				return err
+			}
+			ghlog.Logger.Info("Updated config",
+				zap.String("file", path),
+				zap.String("profile", name),
+				zap.String("key", args[0]))
+			return nil
+		},
+	}
+	cmd.Flags().Bool("local", false, "Write to the repo-local .gh-blob.yml instead of the user config")
+	return cmd
+}
+
+func configList() *cobra.Command {
+	return &cobra.Command{
+		Use:     "list",
+		Short:   "List profiles and their values",
+		Example: `gh blob config list`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.Load()
+			if err != nil {
+				return err
+			}
+			explicit, _ := cmd.Flags().GetString("profile")
+			active := cfg.ProfileName(explicit)
+
+			out := cmd.OutOrStdout()
+			for _, name := range cfg.ProfileNames() {
+				marker := " "
+				if name == active {
+					marker = "*"
+				}
+				fmt.Fprintf(out, "%s %s\n", marker, name)
+				for _, key := range config.Keys {
+					if value := cfg.Profiles[name].Get(key); value != "" {
+						fmt.Fprintf(out, "    %s: %s\n", key, value)
+					}
+				}
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/robandpdx/gh-blob/internal/github"
+
+	"github.com/spf13/cobra"
+)
+
+// addEmitFlags registers the flags that make a command write the artifacts
+// needed to start a migration from an uploaded archive.
+func addEmitFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("emit-uri", false, "Print the gei:// URI of the uploaded archive to stdout")
+	cmd.Flags().String("emit-env", "", "Write BLOB_* variables to this env file ('-' for stdout)")
+	cmd.Flags().Bool("emit-github-output", false, "Append blob_* outputs to $GITHUB_OUTPUT")
+	cmd.Flags().String("emit-migration-vars", "", "Write startRepositoryMigration variables JSON to this file ('-' for stdout)")
+	cmd.Flags().String("archive-type", "git", "Kind of archive for --emit-migration-vars: git or metadata")
+}
+
+// emitRequested reports whether any --emit-* flag was set.
+func emitRequested(cmd *cobra.Command) bool {
+	for _, name := range []string{"emit-uri", "emit-env", "emit-github-output", "emit-migration-vars"} {
+		if cmd.Flags().Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+type outputVar struct {
+	name  string
+	value string
+}
+
+func blobVars(resp *github.UploadArchiveResponse) []outputVar {
+	return []outputVar{
+		{"BLOB_ID", resp.NodeID},
+		{"BLOB_GUID", resp.GUID},
+		{"BLOB_NAME", resp.Name},
+		{"BLOB_SIZE", fmt.Sprintf("%d", resp.Size)},
+		{"BLOB_URI", resp.URI},
+		{"BLOB_CREATED_AT", resp.CreatedAt},
+	}
+}
+
+// migrationVars returns the variables of a startRepositoryMigration mutation
+// that are known once the archive is uploaded. The caller fills in the
+// migration source, repository name and the other archive URL.
+func migrationVars(orgNodeId string, archiveType string, resp *github.UploadArchiveResponse) (map[string]interface{}, error) {
+	vars := map[string]interface{}{
+		"ownerId": orgNodeId,
+	}
+	switch archiveType {
+	case "git":
+		vars["gitArchiveUrl"] = resp.URI
+	case "metadata":
+		vars["metadataArchiveUrl"] = resp.URI
+	default:
+		return nil, fmt.Errorf("invalid archive type %q: must be git or metadata", archiveType)
+	}
+	return vars, nil
+}
+
+// emitArtifacts writes whichever follow-on artifacts were requested by the
+// --emit-* flags for an uploaded archive.
+func emitArtifacts(cmd *cobra.Command, orgNodeId string, resp *github.UploadArchiveResponse) error {
+	out := cmd.OutOrStdout()
+
+	if emitURI, _ := cmd.Flags().GetBool("emit-uri"); emitURI {
+		fmt.Fprintln(out, resp.URI)
+	}
+
+	if path, _ := cmd.Flags().GetString("emit-env"); path != "" {
+		var b strings.Builder
+		for _, v := range blobVars(resp) {
+			fmt.Fprintf(&b, "%s=%s\n", v.name, shellQuote(v.value))
+		}
+		if err := writeArtifact(out, path, b.String(), false); err != nil {
+			return fmt.Errorf("failed to write env file: %w", err)
+		}
+	}
+
+	if emit, _ := cmd.Flags().GetBool("emit-github-output"); emit {
+		path := os.Getenv("GITHUB_OUTPUT")
+		if path == "" {
+			return fmt.Errorf("--emit-github-output requires GITHUB_OUTPUT to be set")
+		}
+		var b strings.Builder
+		for _, v := range blobVars(resp) {
+			if err := writeGitHubOutput(&b, strings.ToLower(v.name), v.value); err != nil {
+				return err
+			}
+		}
+		if err := writeArtifact(out, path, b.String(), true); err != nil {
+			return fmt.Errorf("failed to write GitHub output: %w", err)
+		}
+	}
+
+	if path, _ := cmd.Flags().GetString("emit-migration-vars"); path != "" {
+		archiveType, _ := cmd.Flags().GetString("archive-type")
+		vars, err := migrationVars(orgNodeId, archiveType, resp)
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(vars, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode migration variables: %w", err)
+		}
+		if err := writeArtifact(out, path, string(data)+"\n", false); err != nil {
+			return fmt.Errorf("failed to write migration variables: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// shellQuote returns value as it must be written in an env file that is
+// sourced by a shell: unchanged if it only holds safe characters, otherwise
+// in single quotes.
+func shellQuote(value string) string {
+	safe := value != ""
+	for _, r := range value {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-.,:/@%+=", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// writeGitHubOutput writes one $GITHUB_OUTPUT entry. A value spanning lines
+// uses the name<<delimiter syntax with a random delimiter it cannot contain.
+func writeGitHubOutput(b *strings.Builder, name string, value string) error {
+	if !strings.ContainsAny(value, "\r\n") {
+		fmt.Fprintf(b, "%s=%s\n", name, value)
+		return nil
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return fmt.Errorf("failed to generate output delimiter: %w", err)
+	}
+	delimiter := "ghadelimiter_" + hex.EncodeToString(random)
+	fmt.Fprintf(b, "%s<<%s\n%s\n%s\n", name, delimiter, value, delimiter)
+	return nil
+}
+
+// writeArtifact writes content to path, or to out when path is "-".
+func writeArtifact(out io.Writer, path string, content string, appendTo bool) error {
+	if path == "-" {
+		_, err := io.WriteString(out, content)
+		return err
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if appendTo {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -2,12 +2,25 @@ package cmd
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/robandpdx/gh-blob/internal/archive"
+	"github.com/robandpdx/gh-blob/internal/audit"
 	"github.com/robandpdx/gh-blob/internal/github"
+	"github.com/robandpdx/gh-blob/internal/inventory"
+	"github.com/robandpdx/gh-blob/internal/labels"
+	"github.com/robandpdx/gh-blob/internal/trash"
 	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+	"github.com/robandpdx/gh-blob/pkg/units"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
@@ -19,24 +32,43 @@ func UploadBlob() *cobra.Command {
 		Short: "Upload a blob to GitHub",
 		Long: `Upload a blob to GitHub.
 GitHub credentials must be configured via environment variables.`,
-		Example: `gh blob upload --org my-org --archive-file-path /path/to/archive --timeout 45m`,
-		RunE:    uploadBlob,
+		Example: `gh blob upload --org my-org --archive-file-path /path/to/archive --timeout 45m
+gh blob upload --org my-org --archive-file-path /path/to/archive --emit-env blob.env --emit-github-output
+gh blob upload --org my-org --from-git ./repo
+gh blob upload --org my-org -a 'exports/*.tar.gz' --parallel 2`,
+		RunE: uploadBlob,
 	}
 
 	cmd.Flags().StringP("org", "o", "", "Owner of the repository")
-	cmd.Flags().StringP("archive-file-path", "a", "", "Path to the blob")
-	cmd.Flags().DurationP("timeout", "t", 60*time.Minute, "Timeout for the upload operation (e.g. 30m, 1h15m)")
+	cmd.Flags().StringArrayP("archive-file-path", "a", nil, "Path to the blob; repeat or use a glob such as 'exports/*.tar.gz' to upload several")
+	cmd.Flags().String("from-git", "", "Build a git archive from this local repository and upload it without writing it to disk")
+	cmd.Flags().String("compress", "", "Compress the archive while uploading: gzip or zstd")
+	cmd.Flags().DurationP("timeout", "t", 60*time.Minute, "Timeout for the upload operation (e.g. 30m, 1h15m); 0 for no limit")
+	cmd.Flags().String("part-size", "100MiB", "Size of each part for multipart uploads (e.g. 50MiB, 1GiB)")
+	cmd.Flags().Bool("validate", false, "Check the archive is a usable git or metadata archive before uploading")
+	cmd.Flags().Bool("dedupe", false, "Reuse an archive previously uploaded from this machine with the same checksum, name and size")
+	cmd.Flags().Int("parallel", 1, "Number of archives to upload at once when uploading several")
+	addNamingFlags(cmd)
+	cmd.Flags().StringArray("label", nil, "Label the uploaded blob with key=value (e.g. wave=3); repeat for several")
+	addResumeFlags(cmd)
+	addPartTimeoutFlags(cmd)
+	addThrottleFlags(cmd)
+	addEmitFlags(cmd)
 
 	err := cmd.MarkFlagRequired("org")
 	if err != nil {
 		ghlog.Logger.Error("failed to mark flag as required", zap.Error(err))
 		return nil
 	}
-	err = cmd.MarkFlagRequired("archive-file-path")
-	if err != nil {
-		ghlog.Logger.Error("failed to mark flag as required", zap.Error(err))
-		return nil
-	}
+	cmd.MarkFlagsMutuallyExclusive("archive-file-path", "from-git")
+	cmd.MarkFlagsOneRequired("archive-file-path", "from-git")
+	// A packed repository is streamed while it is built, so there is no
+	// file to validate
+	cmd.MarkFlagsMutuallyExclusive("from-git", "validate")
+	// Deduplication looks up the checksum of a file before uploading it,
+	// which a stream only has once it is uploaded
+	cmd.MarkFlagsMutuallyExclusive("from-git", "dedupe")
+	cmd.MarkFlagsMutuallyExclusive("compress", "dedupe")
 	return cmd
 }
 
@@ -44,10 +76,64 @@ func uploadBlob(cmd *cobra.Command, args []string) error {
 	ghlog.Logger.Info("Reading input values for uploading blob to GitHub")
 
 	org, _ := cmd.Flags().GetString("org")
-	archiveFilePath, _ := cmd.Flags().GetString("archive-file-path")
+	patterns, _ := cmd.Flags().GetStringArray("archive-file-path")
+	fromGit, _ := cmd.Flags().GetString("from-git")
+
+	var archiveFilePaths []string
+	if fromGit == "" {
+		var err error
+		archiveFilePaths, err = expandArchivePaths(patterns)
+		if err != nil {
+			return err
+		}
+		if len(archiveFilePaths) > 1 && emitRequested(cmd) {
+			return fmt.Errorf("--emit-* flags can only be used when uploading a single archive")
+		}
+	}
 
-	if _, err := os.Stat(archiveFilePath); os.IsNotExist(err) {
-		return fmt.Errorf("file does not exist: %s", archiveFilePath)
+	archiveType, _ := cmd.Flags().GetString("archive-type")
+	if archiveType != "git" && archiveType != "metadata" {
+		return fmt.Errorf("invalid archive type %q: must be git or metadata", archiveType)
+	}
+
+	if validate, _ := cmd.Flags().GetBool("validate"); validate {
+		expectedType := ""
+		if cmd.Flags().Changed("archive-type") {
+			expectedType = archiveType
+		}
+		for _, path := range archiveFilePaths {
+			if err := validateArchive(path, expectedType); err != nil {
+				return err
+			}
+		}
+	}
+
+	labelPairs, _ := cmd.Flags().GetStringArray("label")
+	if _, err := labels.Parse(labelPairs); err != nil {
+		return err
+	}
+
+	namer, err := newBlobNamer(cmd, org, len(archiveFilePaths))
+	if err != nil {
+		return err
+	}
+
+	partSizeValue, _ := cmd.Flags().GetString("part-size")
+	partSize, err := units.ParseBytes(partSizeValue)
+	if err != nil {
+		return fmt.Errorf("invalid part size: %w", err)
+	}
+
+	limiter, err := newLimiter(cmd)
+	if err != nil {
+		return err
+	}
+
+	parallel, _ := cmd.Flags().GetInt("parallel")
+	concurrent := min(max(parallel, 1), len(archiveFilePaths))
+	timeout, err := partTimeout(cmd, partSize, limiter, concurrent)
+	if err != nil {
+		return err
 	}
 
 	// Get the GitHub org id
@@ -59,20 +145,250 @@ func uploadBlob(cmd *cobra.Command, args []string) error {
 	var orgDatabaseId = orgInfo.Organization.DatabaseId
 
 	uploadArchiveInput := github.UploadArchiveInput{
-		ArchiveFilePath: archiveFilePath,
-		OrganizationId:  fmt.Sprintf("%d", orgDatabaseId),
+		Organization:   org,
+		OrganizationId: fmt.Sprintf("%d", orgDatabaseId),
+		PartSize:       partSize,
+		Limiter:        limiter,
+		PartTimeout:    timeout,
 	}
 
 	// Create context with user-configurable timeout
-	timeout, _ := cmd.Flags().GetDuration("timeout")
-	ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+	ctx, cancel := timeoutContext(cmd)
 	defer cancel()
 
-	uploadArchiveResponse, err := github.UploadArchiveToGitHub(ctx, uploadArchiveInput)
+	if len(archiveFilePaths) > 1 {
+		results := uploadArchives(ctx, cmd, uploadArchiveInput, archiveFilePaths, parallel, namer)
+		if err := printUploadResults(cmd.OutOrStdout(), results); err != nil {
+			return err
+		}
+		return uploadResultsError(results)
+	}
+
+	var uploadArchiveResponse *github.UploadArchiveResponse
+	if fromGit != "" {
+		uploadArchiveResponse, err = uploadGitRepository(ctx, cmd, uploadArchiveInput, fromGit, namer)
+	} else {
+		uploadArchiveResponse, err = uploadArchiveFile(ctx, cmd, uploadArchiveInput, archiveFilePaths[0], namer)
+	}
+	if err != nil {
+		return err
+	}
+
+	return emitArtifacts(cmd, orgInfo.Organization.ID, uploadArchiveResponse)
+}
+
+// uploadGitRepository packs the repository at repoPath and uploads the
+// archive as a stream.
+func uploadGitRepository(ctx context.Context, cmd *cobra.Command, input github.UploadArchiveInput, repoPath string, namer *blobNamer) (*github.UploadArchiveResponse, error) {
+	ghlog.Logger.Info("Packing git archive", zap.String("repoPath", repoPath))
+	pack, err := archive.NewGitPack(repoPath, "")
+	if err != nil {
+		return nil, err
+	}
+	input.Name = pack.FileName()
+
+	if compression, _ := cmd.Flags().GetString("compress"); compression != "" {
+		open := func() (io.ReadCloser, error) { return pack.Reader(), nil }
+		compressed, err := compressUpload(&input, compression, open)
+		if err != nil {
+			return nil, err
+		}
+		defer compressed.Close()
+	} else {
+		stream := pack.Reader()
+		defer stream.Close()
+		input.Reader = stream
+		input.Size = pack.Size()
+	}
+
+	repo := repoPath
+	if abs, err := filepath.Abs(repoPath); err == nil {
+		repo = filepath.Base(abs)
+	}
+	return uploadNamedArchive(ctx, cmd, input, repo, namer)
+}
+
+// uploadArchiveFile uploads the archive at path, compressing it on the way
+// when --compress is set.
+func uploadArchiveFile(ctx context.Context, cmd *cobra.Command, input github.UploadArchiveInput, path string, namer *blobNamer) (*github.UploadArchiveResponse, error) {
+	input.ArchiveFilePath = path
+	if compression, _ := cmd.Flags().GetString("compress"); compression != "" {
+		existing, err := archive.DetectFileCompression(path)
+		if err != nil {
+			return nil, err
+		}
+		if existing != "" {
+			return nil, fmt.Errorf("%s is already %s compressed, upload it without --compress", path, existing)
+		}
+		input.Name = filepath.Base(path)
+		open := func() (io.ReadCloser, error) {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open file: %w", err)
+			}
+			return file, nil
+		}
+		stream, err := compressUpload(&input, compression, open)
+		if err != nil {
+			return nil, err
+		}
+		defer stream.Close()
+	}
+	return uploadNamedArchive(ctx, cmd, input, "", namer)
+}
+
+// uploadNamedArchive names the upload with namer, then uploads it unless an
+// archive with the name exists and the policy is skip. Archives replaced by
+// the upload are deleted once it succeeds.
+func uploadNamedArchive(ctx context.Context, cmd *cobra.Command, input github.UploadArchiveInput, repo string, namer *blobNamer) (*github.UploadArchiveResponse, error) {
+	existing, replaced, err := namer.resolve(&input, repo)
 	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		labelArchive(cmd, existing)
+		return existing, nil
+	}
+	resp, err := uploadArchive(ctx, cmd, input)
+	if err != nil {
+		return nil, err
+	}
+	labelArchive(cmd, resp)
+	if err := replaceArchives(cmd, input.Organization, resp, replaced); err != nil {
+		return resp, err
+	}
+	return resp, nil
+}
+
+// addResumeFlags adds the flags controlling what happens to an interrupted
+// multipart upload.
+func addResumeFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("resume", false, "Continue an interrupted multipart upload of the same file")
+	cmd.Flags().Bool("abort-on-cancel", false, "Try to abort the upload session on GitHub when interrupted instead of keeping it for --resume (best effort)")
+	cmd.MarkFlagsMutuallyExclusive("resume", "abort-on-cancel")
+}
+
+// compressUpload makes input upload a compressed stream of the archive open
+// returns, under input.Name with the extension of the compression added.
+// The upload API needs the size up front, so the archive is compressed
+// twice: once to measure it and again while it is uploaded, which must give
+// the same bytes. The returned stream must be closed once the upload is
+// done.
+func compressUpload(input *github.UploadArchiveInput, compression string, open func() (io.ReadCloser, error)) (io.Closer, error) {
+	if compression != archive.CompressionGzip && compression != archive.CompressionZstd {
+		return nil, fmt.Errorf("invalid compression %q: must be gzip or zstd", compression)
+	}
+
+	ghlog.Logger.Info("Measuring compressed archive",
+		zap.String("compression", compression),
+		zap.String("name", input.Name))
+	source, err := open()
+	if err != nil {
+		return nil, err
+	}
+	size, sum, err := archive.MeasureCompressed(source, compression)
+	source.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	source, err = open()
+	if err != nil {
+		return nil, err
+	}
+	stream, err := archive.Compress(source, compression)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+	input.Name = archive.CompressedName(input.Name, compression)
+	input.Reader = archive.Expect(stream, size, sum)
+	input.Size = size
+	ghlog.Logger.Info("Compressing archive while uploading",
+		zap.String("compression", compression),
+		zap.String("blobName", input.Name),
+		zap.String("size", units.FormatBytes(size)))
+
+	return closerFunc(func() error {
+		err := stream.Close()
+		source.Close()
+		return err
+	}), nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// uploadArchive uploads one archive and records the outcome in the audit
+// trail and the local inventory.
+func uploadArchive(ctx context.Context, cmd *cobra.Command, input github.UploadArchiveInput) (*github.UploadArchiveResponse, error) {
+	record := audit.Record{
+		Org:       input.Organization,
+		Operation: audit.OperationUpload,
+		Name:      input.Name,
+	}
+	if record.Name == "" {
+		record.Name = filepath.Base(input.ArchiveFilePath)
+	}
+
+	input.Resume, _ = cmd.Flags().GetBool("resume")
+	input.AbortOnCancel, _ = cmd.Flags().GetBool("abort-on-cancel")
+	input.StallTimeout, _ = cmd.Flags().GetDuration("stall-timeout")
+
+	// The checksum is computed while the archive is uploaded, except that
+	// --dedupe needs the checksum of a file before uploading it
+	var hasher hash.Hash
+	dedupe, _ := cmd.Flags().GetBool("dedupe")
+	if dedupe && input.Reader == nil {
+		var err error
+		record.Checksum, err = archive.SHA256File(input.ArchiveFilePath)
+		if err != nil {
+			ghlog.Logger.Warn("failed to compute archive checksum", zap.Error(err))
+		}
+	}
+	if record.Checksum == "" {
+		hasher = sha256.New()
+		input.Hash = hasher
+	}
+
+	if dedupe && record.Checksum != "" {
+		if existing := findDuplicateArchive(input, record.Checksum); existing != nil {
+			return existing, nil
+		}
+	}
+
+	uploadArchiveResponse, err := github.UploadArchiveToGitHub(ctx, input)
+	if err != nil {
+		record.Outcome = audit.OutcomeFailure
+		record.Error = err.Error()
+		recordAudit(cmd, record)
 		ghlog.Logger.Error("failed to upload to GitHub storage", zap.Error(err))
-		return fmt.Errorf("failed to upload to GitHub storage: %w", err)
+		return nil, fmt.Errorf("failed to upload to GitHub storage: %w", err)
 	}
+	if hasher != nil {
+		record.Checksum = hex.EncodeToString(hasher.Sum(nil))
+	}
+	record.Outcome = audit.OutcomeSuccess
+	record.BlobID = uploadArchiveResponse.NodeID
+	record.GUID = uploadArchiveResponse.GUID
+	record.Name = uploadArchiveResponse.Name
+	record.Size = int64(uploadArchiveResponse.Size)
+	recordAudit(cmd, record)
+
+	updateInventory(func(store *inventory.Store) {
+		store.Add(defaultHost(), input.Organization, github.MigrationArchive{
+			GUID:      uploadArchiveResponse.GUID,
+			ID:        uploadArchiveResponse.NodeID,
+			Name:      uploadArchiveResponse.Name,
+			Size:      uploadArchiveResponse.Size,
+			URI:       uploadArchiveResponse.URI,
+			CreatedAt: uploadArchiveResponse.CreatedAt,
+		})
+		if record.Checksum != "" {
+			store.SetChecksum(defaultHost(), input.Organization, record.Checksum, uploadArchiveResponse.NodeID)
+		}
+	})
 	ghlog.Logger.Info("Uploaded archive to GitHub storage successfully")
 	ghlog.Logger.Info("Blob ID: " + uploadArchiveResponse.NodeID)
 	ghlog.Logger.Info("Blob GUID: " + uploadArchiveResponse.GUID)
@@ -81,7 +397,71 @@ func uploadBlob(cmd *cobra.Command, args []string) error {
 	ghlog.Logger.Info("Blob URI: " + uploadArchiveResponse.URI)
 	ghlog.Logger.Info("Blob Created At: " + uploadArchiveResponse.CreatedAt)
 
-	return nil
+	return uploadArchiveResponse, nil
+}
+
+// findDuplicateArchive returns the archive of the input's org recorded in
+// the inventory with the given checksum, if it still exists on GitHub with the
+// same name and size as the file to upload. Any failure means the archive is
+// uploaded again, but only an archive confirmed to be gone is dropped from
+// the inventory.
+func findDuplicateArchive(input github.UploadArchiveInput, checksum string) *github.UploadArchiveResponse {
+	store, err := inventory.OpenDefault()
+	if err != nil {
+		ghlog.Logger.Warn("failed to open local inventory for deduplication", zap.Error(err))
+		return nil
+	}
+	id, ok := store.FindByChecksum(defaultHost(), input.Organization, checksum)
+	if !ok {
+		return nil
+	}
+
+	info, err := os.Stat(input.ArchiveFilePath)
+	if err != nil {
+		return nil
+	}
+	blob, err := github.QueryBlobFromGitHub(id)
+	if err != nil && !github.IsNodeNotFound(err) {
+		// The archive may well still exist, so keep it in the inventory
+		ghlog.Logger.Warn("failed to look up archive with the same checksum, uploading again",
+			zap.String("id", id),
+			zap.Error(err))
+		return nil
+	}
+	if err != nil || blob.Node.MigrationArchive.ID == "" {
+		ghlog.Logger.Info("Archive with the same checksum no longer exists, uploading again",
+			zap.String("id", id),
+			zap.String("checksum", checksum))
+		updateInventory(func(store *inventory.Store) {
+			store.RemoveByID(id)
+		})
+		return nil
+	}
+	existing := blob.Node.MigrationArchive
+	name := input.Name
+	if name == "" {
+		name = filepath.Base(input.ArchiveFilePath)
+	}
+	if existing.Name != name || int64(existing.Size) != info.Size() {
+		ghlog.Logger.Info("Archive with the same checksum has a different name or size, uploading again",
+			zap.String("id", id),
+			zap.String("name", existing.Name),
+			zap.Int("size", existing.Size))
+		return nil
+	}
+
+	ghlog.Logger.Info("Reusing archive with the same checksum instead of uploading",
+		zap.String("id", existing.ID),
+		zap.String("name", existing.Name),
+		zap.String("checksum", checksum))
+	return &github.UploadArchiveResponse{
+		GUID:      existing.GUID,
+		NodeID:    existing.ID,
+		Name:      existing.Name,
+		Size:      existing.Size,
+		URI:       existing.URI,
+		CreatedAt: existing.CreatedAt,
+	}
 }
 
 func DeleteBlob() *cobra.Command {
@@ -90,52 +470,165 @@ func DeleteBlob() *cobra.Command {
 		Short: "Delete a blob from GitHub",
 		Long: `Delete a blob from GitHub.
 GitHub credentials must be configured via environment variables.`,
-		Example: `gh blob delete --id <blob-id>`,
-		RunE:    deleteBlob,
-	}
-	cmd.Flags().StringP("id", "i", "", "ID of the blob to delete")
-	err := cmd.MarkFlagRequired("id")
-	if err != nil {
-		ghlog.Logger.Error("failed to mark flag as required", zap.Error(err))
-		return nil
+		Example: `gh blob delete --id <blob-id>
+gh blob delete --id <blob-id> --org my-org --force
+gh blob delete --org my-org --uri gei://archive/<guid>
+gh blob delete --id <blob-id> --org my-org --soft --retention 24h`,
+		RunE: deleteBlob,
 	}
+	addBlobSelectorFlags(cmd, "delete")
+	cmd.Flags().StringP("org", "o", "", "Organization owning the blob, used for --guid, --name and --uri and to check for active migrations")
+	cmd.Flags().Bool("force", false, "Delete even if the blob is used by a queued or in-progress migration")
+	cmd.Flags().Bool("soft", false, "Move the blob to the local trash instead of deleting it; trash purge deletes it later")
+	cmd.Flags().Duration("retention", trash.DefaultRetention, "How long a soft deleted blob stays in the trash before it can be purged")
+	// A soft delete leaves the blob on GitHub, so there is nothing to force
+	cmd.MarkFlagsMutuallyExclusive("soft", "force")
 	return cmd
 }
 
 func deleteBlob(cmd *cobra.Command, args []string) error {
 	ghlog.Logger.Info("Reading input values for deleting blob from GitHub")
 
-	id, _ := cmd.Flags().GetString("id")
+	org, _ := cmd.Flags().GetString("org")
+	force, _ := cmd.Flags().GetBool("force")
+
+	id, err := resolveBlobID(cmd, org)
+	if err != nil {
+		return err
+	}
+
+	if soft, _ := cmd.Flags().GetBool("soft"); soft {
+		retention, _ := cmd.Flags().GetDuration("retention")
+		return softDeleteArchive(cmd, org, id, retention)
+	}
+	return deleteArchive(cmd, org, id, force)
+}
+
+// deleteArchive deletes one archive and records the outcome in the audit
+// trail and the local inventory. Unless force is set it refuses to delete an
+// archive that a queued or in-progress migration in org still reads from.
+func deleteArchive(cmd *cobra.Command, org string, id string, force bool) error {
+	record := audit.Record{
+		Org:       org,
+		Operation: audit.OperationDelete,
+		BlobID:    id,
+	}
+	// Look up the blob first so the audit record describes what was removed
+	blob, lookupErr := github.QueryBlobFromGitHub(id)
+	if github.IsNodeNotFound(lookupErr) || lookupErr == nil && blob.Node.MigrationArchive.ID == "" {
+		lookupErr = fmt.Errorf("%w: %s", github.ErrBlobNotFound, id)
+	}
+	if lookupErr == nil {
+		record.GUID = blob.Node.MigrationArchive.GUID
+		record.Name = blob.Node.MigrationArchive.Name
+		record.Size = int64(blob.Node.MigrationArchive.Size)
+	}
 
-	if id == "" {
-		return fmt.Errorf("ID is required")
+	if !force {
+		if errors.Is(lookupErr, github.ErrBlobNotFound) {
+			return lookupErr
+		}
+		if lookupErr != nil {
+			return fmt.Errorf("failed to look up blob %s, use --force to delete it anyway: %w", id, lookupErr)
+		}
+		if err := ensureNotInUse(org, id, blob.Node.MigrationArchive.URI); err != nil {
+			return err
+		}
 	}
 
 	// Delete the blob (no context needed for this operation now)
 	err := github.DeleteBlobFromGitHub(id)
 	if err != nil {
+		record.Outcome = audit.OutcomeFailure
+		record.Error = err.Error()
+		recordAudit(cmd, record)
 		ghlog.Logger.Error("failed to delete blob from GitHub", zap.Error(err))
 		return fmt.Errorf("failed to delete blob from GitHub: %w", err)
 	}
+	record.Outcome = audit.OutcomeSuccess
+	recordAudit(cmd, record)
+
+	updateInventory(func(store *inventory.Store) {
+		store.RemoveByID(id)
+	})
 	ghlog.Logger.Info("Deleted blob from GitHub successfully")
 	return nil
 }
 
+// ensureNotInUse returns an error naming the blocking migrations if the
+// archive may be read by a queued or in-progress migration. Only migrations
+// started by migrate on this machine have their archives recorded; any other
+// active migration in the org, e.g. one started by gh gei or from CI, might
+// read from the archive too and blocks the delete as well. The org is taken
+// from the local inventory when not given.
+func ensureNotInUse(org string, id string, uri string) error {
+	if org == "" {
+		if store, err := inventory.OpenDefault(); err == nil {
+			if inv, ok := store.FindByID(id); ok {
+				org = inv.Org
+			}
+		}
+	}
+	if org == "" {
+		return fmt.Errorf("cannot determine the organization of blob %s to check for active migrations, use --org or --force", id)
+	}
+
+	uris, err := migrationArchiveUris(org)
+	if err != nil {
+		return fmt.Errorf("failed to check for active migrations, use --force to delete anyway: %w", err)
+	}
+	using, unknown, err := github.ActiveMigrationsUsing(org, uri, uris)
+	if err != nil {
+		return fmt.Errorf("failed to check for active migrations, use --force to delete anyway: %w", err)
+	}
+	if len(using) == 0 && len(unknown) == 0 {
+		return nil
+	}
+
+	migrationIds := func(migrations []github.RepositoryMigration) []string {
+		ids := make([]string, 0, len(migrations))
+		for _, migration := range migrations {
+			ids = append(ids, migration.ID)
+		}
+		return ids
+	}
+	ghlog.Logger.Error("Refusing to delete blob while migrations that may use it are active",
+		zap.String("org", org),
+		zap.String("id", id),
+		zap.Strings("usingMigrationIds", migrationIds(using)),
+		zap.Strings("unknownMigrationIds", migrationIds(unknown)))
+	if len(using) > 0 {
+		return fmt.Errorf("blob %s is used by active migrations %s, use --force to delete it anyway", id, strings.Join(migrationIds(using), ", "))
+	}
+	return fmt.Errorf("blob %s may be used by active migrations %s, which were not started by gh blob migrate on this machine; use --force to delete it anyway", id, strings.Join(migrationIds(unknown), ", "))
+}
+
 func QueryAllBlobs() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "query-all",
 		Short: "Query all blobs from GitHub",
 		Long: `Query all blobs from GitHub.
 GitHub credentials must be configured via environment variables.`,
-		Example: `gh blob query-all --org my-org`,
-		RunE:    queryAllBlobs,
+		Example: `gh blob query-all --org my-org
+gh blob query-all --org my-org --cached
+gh blob query-all --enterprise my-enterprise --output table
+gh blob query-all --org my-org --label wave=3
+gh blob query-all --org my-org --watch --interval 30s --output json`,
+		RunE: queryAllBlobs,
 	}
 	cmd.Flags().StringP("org", "o", "", "Owner of the repository")
-	err := cmd.MarkFlagRequired("org")
-	if err != nil {
-		ghlog.Logger.Error("failed to mark flag as required", zap.Error(err))
-		return nil
-	}
+	cmd.Flags().StringP("enterprise", "e", "", "Query every organization in this enterprise")
+	cmd.Flags().Int("concurrency", 4, "Number of organizations to query at once with --enterprise")
+	cmd.Flags().Bool("cached", false, "Read from the local inventory instead of GitHub")
+	cmd.Flags().Duration("max-age", inventory.DefaultMaxAge, "Warn when the cached inventory is older than this")
+	addOutputFlag(cmd, "log", "json", "table")
+	cmd.Flags().StringArray("label", nil, "Only list blobs with this key=value label; repeat to require several")
+	cmd.Flags().Bool("watch", false, "Keep polling and print only archives added or removed since the previous poll")
+	cmd.Flags().Duration("interval", 30*time.Second, "Time between polls with --watch")
+	cmd.MarkFlagsMutuallyExclusive("org", "enterprise")
+	cmd.MarkFlagsMutuallyExclusive("watch", "cached")
+	cmd.MarkFlagsOneRequired("org", "enterprise")
+	offlineWith(cmd, "cached")
 	return cmd
 }
 
@@ -143,17 +636,67 @@ func queryAllBlobs(cmd *cobra.Command, args []string) error {
 	ghlog.Logger.Info("Reading input values for querying all blobs from GitHub")
 
 	org, _ := cmd.Flags().GetString("org")
+	enterprise, _ := cmd.Flags().GetString("enterprise")
+	cached, _ := cmd.Flags().GetBool("cached")
+	output, _ := cmd.Flags().GetString("output")
 
-	if org == "" {
-		return fmt.Errorf("organization is required")
+	if org == "" && enterprise == "" {
+		return fmt.Errorf("organization or enterprise is required")
+	}
+
+	pairs, _ := cmd.Flags().GetStringArray("label")
+	selector, err := labels.Parse(pairs)
+	if err != nil {
+		return err
+	}
+	store, err := labels.OpenDefault()
+	if err != nil {
+		return err
 	}
 
-	_, err := github.QueryAllBlobsFromGitHub(org)
+	watch, _ := cmd.Flags().GetBool("watch")
+
+	// Blobs are logged page by page unless there are labels to join
+	if enterprise == "" && !cached && !watch && output == "log" && len(store.Blobs) == 0 && len(selector) == 0 {
+		_, err := github.QueryAllBlobsFromGitHub(org)
+		if err != nil {
+			ghlog.Logger.Error("failed to query blobs from GitHub", zap.Error(err))
+			return fmt.Errorf("failed to query blobs from GitHub: %w", err)
+		}
+		ghlog.Logger.Info("Queried blobs from GitHub successfully")
+		return nil
+	}
+
+	var orgs []string
+	if org != "" {
+		orgs = []string{org}
+	}
+	orgs, err = resolveOrgs(orgs, enterprise)
+	if err != nil {
+		return err
+	}
+
+	maxAge, _ := cmd.Flags().GetDuration("max-age")
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+	if watch {
+		interval, _ := cmd.Flags().GetDuration("interval")
+		return watchArchives(cmd, orgs, selector, output, interval, concurrency)
+	}
+	archivesByOrg, err := fetchArchives(orgs, cached, maxAge, concurrency)
+	if err != nil {
+		ghlog.Logger.Error("failed to query blobs", zap.Error(err))
+		return fmt.Errorf("failed to query blobs: %w", err)
+	}
+
+	archives, err := withLabels(mergeArchives(archivesByOrg), selector)
 	if err != nil {
-		ghlog.Logger.Error("failed to query blobs from GitHub", zap.Error(err))
-		return fmt.Errorf("failed to query blobs from GitHub: %w", err)
+		return err
+	}
+	if err := printArchives(cmd.OutOrStdout(), archives, output); err != nil {
+		return err
 	}
-	ghlog.Logger.Info("Queried blobs from GitHub successfully")
+	ghlog.Logger.Info("Queried blobs successfully",
+		zap.Int("organizations", len(orgs)))
 
 	return nil
 }
@@ -164,27 +707,25 @@ func QueryBlob() *cobra.Command {
 		Short: "Query a blob from GitHub",
 		Long: `Query a blob from GitHub.
 GitHub credentials must be configured via environment variables.`,
-		Example: `gh blob query --id <blob-id>`,
-		RunE:    queryBlob,
-	}
-	cmd.Flags().StringP("id", "i", "", "ID of the blob to query")
-	err := cmd.MarkFlagRequired("id")
-	if err != nil {
-		ghlog.Logger.Error("failed to mark flag as required", zap.Error(err))
-		return nil
+		Example: `gh blob query --id <blob-id>
+gh blob query --org my-org --guid <guid>
+gh blob query --org my-org --name repo.tar.gz`,
+		RunE: queryBlob,
 	}
+	addBlobSelectorFlags(cmd, "query")
+	cmd.Flags().StringP("org", "o", "", "Organization to search for --guid, --name and --uri")
 	return cmd
 }
 func queryBlob(cmd *cobra.Command, args []string) error {
 	ghlog.Logger.Info("Reading input values for querying blob from GitHub")
 
-	id, _ := cmd.Flags().GetString("id")
-
-	if id == "" {
-		return fmt.Errorf("ID is required")
+	org, _ := cmd.Flags().GetString("org")
+	id, err := resolveBlobID(cmd, org)
+	if err != nil {
+		return err
 	}
 
-	_, err := github.QueryBlobFromGitHub(id)
+	_, err = github.QueryBlobFromGitHub(id)
 	if err != nil {
 		ghlog.Logger.Error("failed to query blob from GitHub", zap.Error(err))
 		return fmt.Errorf("failed to query blob from GitHub: %w", err)
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cli/go-gh/v2/pkg/auth"
+	"github.com/robandpdx/gh-blob/internal/github"
+	"github.com/robandpdx/gh-blob/internal/inventory"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// defaultHost returns the GitHub host the API clients talk to.
+func defaultHost() string {
+	host, _ := auth.DefaultHost()
+	return host
+}
+
+// updateInventory applies fn to the local inventory and saves it. The
+// inventory is only a cache, so failures are logged and otherwise ignored.
+func updateInventory(fn func(store *inventory.Store)) {
+	err := inventory.UpdateDefault(func(store *inventory.Store) error {
+		fn(store)
+		return nil
+	})
+	if err != nil {
+		ghlog.Logger.Warn("failed to update local inventory", zap.Error(err))
+	}
+}
+
+func InventoryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "inventory",
+		Short: "Manage the local inventory of migration archives",
+		Long: `Manage the local inventory of migration archives.
+The inventory caches each organization's archives so that query-all --cached
+works instantly and offline. upload and delete keep it up to date.`,
+	}
+	cmd.AddCommand(inventorySync(), inventoryStatus())
+	return cmd
+}
+
+func inventorySync() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Refresh the inventory from GitHub",
+		Long: `Refresh the inventory from GitHub.
+Only archives created since the last sync are added unless --full is given.
+An incremental sync does not notice archives deleted by other tools or on
+other machines; use --full to drop them.
+Without --org every organization already in the inventory is refreshed.`,
+		Example: `gh blob inventory sync --org my-org
+gh blob inventory sync --full`,
+		Args: cobra.NoArgs,
+		RunE: syncInventory,
+	}
+	cmd.Flags().StringP("org", "o", "", "Organization to sync")
+	cmd.Flags().Bool("full", false, "Refetch every archive instead of only new ones")
+	return cmd
+}
+
+func syncInventory(cmd *cobra.Command, args []string) error {
+	org, _ := cmd.Flags().GetString("org")
+	full, _ := cmd.Flags().GetBool("full")
+
+	store, err := inventory.OpenDefault()
+	if err != nil {
+		return err
+	}
+
+	host := defaultHost()
+	var orgs []string
+	if org != "" {
+		orgs = []string{org}
+	} else {
+		for _, inv := range store.List() {
+			if inv.Host == host {
+				orgs = append(orgs, inv.Org)
+			}
+		}
+		if len(orgs) == 0 {
+			return fmt.Errorf("inventory is empty, use --org to sync an organization")
+		}
+	}
+
+	// Each org is listed before the inventory is locked, so other commands
+	// are not kept waiting for the API
+	for _, o := range orgs {
+		listedAt := time.Now()
+		archives, _, err := github.ListMigrationArchives(o, "")
+		if err != nil {
+			return fmt.Errorf("failed to sync inventory for %s: %w", o, err)
+		}
+		var added, total int
+		err = inventory.UpdateDefault(func(store *inventory.Store) error {
+			added = store.Merge(host, o, archives, listedAt, full)
+			total = len(store.Get(host, o).Archives)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		ghlog.Logger.Info("Synced inventory",
+			zap.String("org", o),
+			zap.Int("added", added),
+			zap.Int("total", total))
+	}
+	return nil
+}
+
+func inventoryStatus() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "status",
+		Short:   "Show when each organization was last synced",
+		Example: `gh blob inventory status --max-age 6h`,
+		Args:    cobra.NoArgs,
+		RunE:    showInventoryStatus,
+	}
+	cmd.Flags().Duration("max-age", inventory.DefaultMaxAge, "Report inventories older than this as stale")
+	offline(cmd)
+	return cmd
+}
+
+func showInventoryStatus(cmd *cobra.Command, args []string) error {
+	maxAge, _ := cmd.Flags().GetDuration("max-age")
+
+	store, err := inventory.OpenDefault()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tORG\tARCHIVES\tLAST SYNCED\tSTATUS")
+	for _, inv := range store.List() {
+		lastSynced := "never"
+		if !inv.LastSynced.IsZero() {
+			lastSynced = inv.LastSynced.Format(time.RFC3339) + " (" + inv.Age().Round(time.Second).String() + " ago)"
+		}
+		status := "fresh"
+		if inv.Stale(maxAge) {
+			status = "stale"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", inv.Host, inv.Org, len(inv.Archives), lastSynced, status)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/github"
+	"github.com/robandpdx/gh-blob/internal/labels"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// defaultLabelsFile is the file holding the labels in a synced gist or
+// repository.
+const defaultLabelsFile = "gh-blob-labels.json"
+
+// labelsMu serializes updates from concurrent uploads.
+var labelsMu sync.Mutex
+
+// labelArchive adds the --label values of cmd to the archive. Labels are
+// metadata kept on this machine, so failures are logged and otherwise
+// ignored.
+func labelArchive(cmd *cobra.Command, resp *github.UploadArchiveResponse) {
+	pairs, _ := cmd.Flags().GetStringArray("label")
+	if len(pairs) == 0 {
+		return
+	}
+	values, err := labels.Parse(pairs)
+	if err == nil {
+		labelsMu.Lock()
+		defer labelsMu.Unlock()
+		var store *labels.Store
+		store, err = labels.OpenDefault()
+		if err == nil {
+			store.Set(resp.GUID, values)
+			err = store.Save()
+		}
+	}
+	if err != nil {
+		ghlog.Logger.Warn("failed to save labels", zap.Error(err))
+		return
+	}
+	ghlog.Logger.Info("Labeled archive",
+		zap.String("guid", resp.GUID),
+		zap.String("labels", labels.Format(values)))
+}
+
+// withLabels attaches the local labels to archives and keeps those matching
+// selector.
+func withLabels(archives []orgArchive, selector map[string]string) ([]orgArchive, error) {
+	store, err := labels.OpenDefault()
+	if err != nil {
+		return nil, err
+	}
+	var matched []orgArchive
+	for _, archive := range archives {
+		archive.Labels = store.Get(archive.GUID)
+		if labels.Matches(archive.Labels, selector) {
+			matched = append(matched, archive)
+		}
+	}
+	return matched, nil
+}
+
+func LabelsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "labels",
+		Short: "Manage labels of uploaded blobs",
+		Long: `Manage labels of uploaded blobs.
+upload --label stores key=value labels for a blob in a local file keyed by
+its GUID, and query-all --label filters by them. labels sync shares the file
+through a gist or a repository.`,
+	}
+	cmd.AddCommand(labelsList(), labelsSync())
+	return cmd
+}
+
+func labelsList() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list",
+		Short:   "List the labels of every blob",
+		Example: `gh blob labels list --output json`,
+		Args:    cobra.NoArgs,
+		RunE:    listLabels,
+	}
+	addOutputFlag(cmd, "table", "json")
+	offline(cmd)
+	return cmd
+}
+
+func listLabels(cmd *cobra.Command, args []string) error {
+	store, err := labels.OpenDefault()
+	if err != nil {
+		return err
+	}
+
+	output, _ := cmd.Flags().GetString("output")
+	out := cmd.OutOrStdout()
+	switch output {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(store.Blobs)
+	case "table":
+		guids := make([]string, 0, len(store.Blobs))
+		for guid := range store.Blobs {
+			guids = append(guids, guid)
+		}
+		sort.Strings(guids)
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "GUID\tLABELS\tUPDATED")
+		for _, guid := range guids {
+			entry := store.Blobs[guid]
+			fmt.Fprintf(w, "%s\t%s\t%s\n", guid, labels.Format(entry.Labels), entry.UpdatedAt.Format(time.RFC3339))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("invalid output format %q: must be table or json", output)
+	}
+}
+
+func labelsSync() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Merge the local labels with a copy in a gist or repository",
+		Long: `Merge the local labels with a copy in a gist or repository.
+Labels of a blob changed more recently on either side win. The merged labels
+are saved locally and written back when the remote copy is out of date.`,
+		Example: `gh blob labels sync --gist <gist-id>
+gh blob labels sync --repo my-org/migration-state --path labels/gh-blob.json`,
+		Args: cobra.NoArgs,
+		RunE: syncLabels,
+	}
+	cmd.Flags().String("gist", "", "ID of the gist holding the labels")
+	cmd.Flags().String("repo", "", "Repository holding the labels, as owner/name")
+	cmd.Flags().String("path", defaultLabelsFile, "File name in the gist or path in the repository")
+	cmd.MarkFlagsMutuallyExclusive("gist", "repo")
+	cmd.MarkFlagsOneRequired("gist", "repo")
+	return cmd
+}
+
+func syncLabels(cmd *cobra.Command, args []string) error {
+	gist, _ := cmd.Flags().GetString("gist")
+	repo, _ := cmd.Flags().GetString("repo")
+	path, _ := cmd.Flags().GetString("path")
+
+	labelsMu.Lock()
+	defer labelsMu.Unlock()
+	store, err := labels.OpenDefault()
+	if err != nil {
+		return err
+	}
+
+	var content, sha string
+	var found bool
+	if gist != "" {
+		content, found, err = github.GetGistFile(gist, path)
+	} else {
+		content, sha, found, err = github.GetRepoFile(repo, path)
+	}
+	if err != nil {
+		return err
+	}
+
+	pulled := false
+	if found {
+		remote, err := labels.Decode([]byte(content))
+		if err != nil {
+			return fmt.Errorf("failed to parse remote labels %s: %w", path, err)
+		}
+		pulled = store.Merge(remote)
+	}
+	if err := store.Save(); err != nil {
+		return err
+	}
+
+	data, err := store.Marshal()
+	if err != nil {
+		return err
+	}
+	pushed := string(data) != content
+	if pushed {
+		if gist != "" {
+			err = github.UpdateGistFile(gist, path, string(data))
+		} else {
+			err = github.PutRepoFile(repo, path, string(data), sha, "Update gh-blob labels")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	ghlog.Logger.Info("Synced labels",
+		zap.Int("blobs", len(store.Blobs)),
+		zap.Bool("pulled", pulled),
+		zap.Bool("pushed", pushed))
+	return nil
+}
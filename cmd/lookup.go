@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/robandpdx/gh-blob/internal/github"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// addBlobSelectorFlags adds --id and the alternative --guid, --name and
+// --uri lookups to a command that operates on one blob. Exactly one of them
+// must be given.
+func addBlobSelectorFlags(cmd *cobra.Command, action string) {
+	cmd.Flags().StringP("id", "i", "", "ID of the blob to "+action)
+	cmd.Flags().String("guid", "", "GUID of the blob to "+action+", looked up in --org")
+	cmd.Flags().String("name", "", "File name of the blob to "+action+", looked up in --org")
+	cmd.Flags().String("uri", "", "URI (gei://archive/...) of the blob to "+action+", looked up in --org")
+	cmd.MarkFlagsMutuallyExclusive("id", "guid", "name", "uri")
+	cmd.MarkFlagsOneRequired("id", "guid", "name", "uri")
+}
+
+// resolveBlobID returns the node ID selected by the flags added with
+// addBlobSelectorFlags, searching the archives of org for a GUID, name or
+// URI.
+func resolveBlobID(cmd *cobra.Command, org string) (string, error) {
+	id, _ := cmd.Flags().GetString("id")
+	if id != "" {
+		return id, nil
+	}
+
+	selector := github.ArchiveSelector{}
+	selector.GUID, _ = cmd.Flags().GetString("guid")
+	selector.Name, _ = cmd.Flags().GetString("name")
+	selector.URI, _ = cmd.Flags().GetString("uri")
+	if selector.IsZero() {
+		return "", fmt.Errorf("ID is required")
+	}
+	if org == "" {
+		return "", fmt.Errorf("--org is required to look up a blob by %s", selector)
+	}
+
+	id, err := github.ResolveArchiveID(org, selector)
+	if err != nil {
+		return "", err
+	}
+	ghlog.Logger.Debug("Resolved blob ID",
+		zap.String("org", org),
+		zap.String("selector", selector.String()),
+		zap.String("id", id))
+	return id, nil
+}
@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/archive"
+	"github.com/robandpdx/gh-blob/internal/github"
+	"github.com/robandpdx/gh-blob/internal/inventory"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+	"github.com/robandpdx/gh-blob/pkg/units"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func Migrate() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Upload migration archives and start a repository migration",
+		Long: `Upload a git archive and a metadata archive to GitHub owned storage, start a
+repository migration from them and wait for it to finish.
+GitHub credentials must be configured via environment variables.`,
+		Example: `gh blob migrate --org my-org --repo my-repo \
+  --git-archive git.tar.gz --metadata-archive metadata.tar.gz \
+  --source-repo-url https://ghes.example.com/source-org/my-repo --delete-archives`,
+		Args: cobra.NoArgs,
+		RunE: migrate,
+	}
+
+	cmd.Flags().StringP("org", "o", "", "Organization to migrate the repository into")
+	cmd.Flags().StringP("repo", "r", "", "Name of the repository to create")
+	cmd.Flags().String("git-archive", "", "Path to the git archive")
+	cmd.Flags().String("metadata-archive", "", "Path to the metadata archive")
+	cmd.Flags().String("source-repo-url", "", "URL of the repository the archives were exported from")
+	cmd.Flags().String("source-id", "", "Existing migration source to use instead of creating one")
+	cmd.Flags().String("target-repo-visibility", "private", "Visibility of the new repository: private, internal or public")
+	cmd.Flags().String("part-size", "100MiB", "Size of each part for multipart uploads (e.g. 50MiB, 1GiB)")
+	cmd.Flags().Bool("validate", false, "Check both archives are usable before uploading them")
+	cmd.Flags().Bool("dedupe", false, "Reuse archives previously uploaded from this machine with the same checksum, name and size")
+	addResumeFlags(cmd)
+	addPartTimeoutFlags(cmd)
+	addThrottleFlags(cmd)
+	cmd.Flags().Bool("wait", true, "Wait for the migration to finish")
+	cmd.Flags().Duration("poll-interval", github.DefaultMigrationPollInterval, "How often to check the migration state")
+	cmd.Flags().DurationP("timeout", "t", 6*time.Hour, "Timeout for uploading and migrating (e.g. 2h); 0 for no limit")
+	cmd.Flags().Bool("delete-archives", false, "Delete both archives after the migration succeeds")
+
+	for _, name := range []string{"org", "repo", "git-archive", "metadata-archive", "source-repo-url"} {
+		if err := cmd.MarkFlagRequired(name); err != nil {
+			ghlog.Logger.Error("failed to mark flag as required", zap.Error(err))
+			return nil
+		}
+	}
+	return cmd
+}
+
+func migrate(cmd *cobra.Command, args []string) error {
+	ghlog.Logger.Info("Reading input values for migrating repository")
+
+	org, _ := cmd.Flags().GetString("org")
+	repo, _ := cmd.Flags().GetString("repo")
+	gitArchive, _ := cmd.Flags().GetString("git-archive")
+	metadataArchive, _ := cmd.Flags().GetString("metadata-archive")
+	sourceRepoUrl, _ := cmd.Flags().GetString("source-repo-url")
+	sourceId, _ := cmd.Flags().GetString("source-id")
+	visibility, _ := cmd.Flags().GetString("target-repo-visibility")
+	wait, _ := cmd.Flags().GetBool("wait")
+	pollInterval, _ := cmd.Flags().GetDuration("poll-interval")
+	deleteArchives, _ := cmd.Flags().GetBool("delete-archives")
+
+	for _, path := range []string{gitArchive, metadataArchive} {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return fmt.Errorf("file does not exist: %s", path)
+		}
+	}
+	if validate, _ := cmd.Flags().GetBool("validate"); validate {
+		if err := validateArchive(gitArchive, archive.TypeGit); err != nil {
+			return err
+		}
+		if err := validateArchive(metadataArchive, archive.TypeMetadata); err != nil {
+			return err
+		}
+	}
+	if deleteArchives && !wait {
+		return fmt.Errorf("--delete-archives requires waiting for the migration to finish")
+	}
+
+	partSizeValue, _ := cmd.Flags().GetString("part-size")
+	partSize, err := units.ParseBytes(partSizeValue)
+	if err != nil {
+		return fmt.Errorf("invalid part size: %w", err)
+	}
+
+	limiter, err := newLimiter(cmd)
+	if err != nil {
+		return err
+	}
+	timeout, err := partTimeout(cmd, partSize, limiter, 1)
+	if err != nil {
+		return err
+	}
+
+	orgInfo, err := github.GetOrgInfo(org)
+	if err != nil {
+		return fmt.Errorf("failed to fetch organization information: %w", err)
+	}
+
+	ctx, cancel := timeoutContext(cmd)
+	defer cancel()
+
+	var uploaded []*github.UploadArchiveResponse
+	for _, path := range []string{gitArchive, metadataArchive} {
+		resp, err := uploadArchive(ctx, cmd, github.UploadArchiveInput{
+			ArchiveFilePath: path,
+			Organization:    org,
+			OrganizationId:  fmt.Sprintf("%d", orgInfo.Organization.DatabaseId),
+			PartSize:        partSize,
+			Limiter:         limiter,
+			PartTimeout:     timeout,
+		})
+		if err != nil {
+			cleanUpArchives(cmd, org, uploaded, deleteArchives)
+			return err
+		}
+		uploaded = append(uploaded, resp)
+	}
+	gitBlob, metadataBlob := uploaded[0], uploaded[1]
+
+	if sourceId == "" {
+		sourceId, err = github.CreateMigrationSource(orgInfo.Organization.ID, "gh-blob archive source")
+		if err != nil {
+			cleanUpArchives(cmd, org, uploaded, deleteArchives)
+			return err
+		}
+	}
+
+	migration, err := github.StartRepositoryMigration(github.StartMigrationInput{
+		SourceId:             sourceId,
+		OwnerId:              orgInfo.Organization.ID,
+		SourceRepositoryUrl:  sourceRepoUrl,
+		RepositoryName:       repo,
+		GitArchiveUrl:        gitBlob.URI,
+		MetadataArchiveUrl:   metadataBlob.URI,
+		TargetRepoVisibility: visibility,
+	})
+	if err != nil {
+		cleanUpArchives(cmd, org, uploaded, deleteArchives)
+		return err
+	}
+	updateInventory(func(store *inventory.Store) {
+		store.SetMigrationArchives(defaultHost(), org, migration.ID, []string{gitBlob.URI, metadataBlob.URI})
+	})
+	ghlog.Logger.Info("Started repository migration",
+		zap.String("migrationId", migration.ID),
+		zap.String("repository", repo),
+		zap.String("state", migration.State))
+
+	if !wait {
+		return nil
+	}
+
+	migration, err = github.WaitForRepositoryMigration(ctx, migration.ID, pollInterval)
+	if err != nil {
+		return err
+	}
+
+	if migration.State != github.MigrationStateSucceeded {
+		ghlog.Logger.Error("Repository migration failed",
+			zap.String("migrationId", migration.ID),
+			zap.String("state", migration.State),
+			zap.String("failureReason", migration.FailureReason))
+		return fmt.Errorf("migration %s finished in state %s: %s", migration.ID, migration.State, migration.FailureReason)
+	}
+	ghlog.Logger.Info("Repository migration succeeded",
+		zap.String("migrationId", migration.ID),
+		zap.Int("warnings", migration.WarningsCount),
+		zap.String("migrationLogUrl", migration.MigrationLogUrl))
+
+	if deleteArchives {
+		for _, blob := range uploaded {
+			if err := deleteArchive(cmd, org, blob.NodeID, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// cleanUpArchives handles the archives uploaded by a migrate run that failed
+// before the migration started. With --delete-archives they are deleted,
+// otherwise their IDs are logged so they can be reused by a retry with
+// --dedupe or deleted by hand. Failures are logged, as the run has already
+// failed.
+func cleanUpArchives(cmd *cobra.Command, org string, uploaded []*github.UploadArchiveResponse, deleteArchives bool) {
+	hint := "delete it with gh blob delete --id"
+	if dedupe, _ := cmd.Flags().GetBool("dedupe"); dedupe {
+		hint = "a retry with --dedupe reuses it, or delete it with gh blob delete --id"
+	}
+	for _, blob := range uploaded {
+		if !deleteArchives {
+			ghlog.Logger.Warn("Kept the archive uploaded for the failed migration; "+hint,
+				zap.String("id", blob.NodeID),
+				zap.String("name", blob.Name),
+				zap.String("uri", blob.URI))
+			continue
+		}
+		if err := deleteArchive(cmd, org, blob.NodeID, false); err != nil {
+			ghlog.Logger.Warn("failed to delete archive uploaded for the failed migration",
+				zap.String("id", blob.NodeID),
+				zap.Error(err))
+		}
+	}
+}
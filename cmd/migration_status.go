@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/robandpdx/gh-blob/internal/github"
+	"github.com/robandpdx/gh-blob/internal/inventory"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// migrationStatus is a repository migration together with the archives of
+// the organization it reads from.
+type migrationStatus struct {
+	github.RepositoryMigration
+	Active   bool                      `json:"active"`
+	Archives []github.MigrationArchive `json:"archives"`
+}
+
+func MigrationStatus() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migration-status",
+		Short: "Show repository migrations and the archives they use",
+		Long: `Show repository migrations of an organization and correlate them with the
+organization's migration archives, so you can tell which archives are still
+needed by queued or in-progress migrations.
+GitHub does not report the archives a migration reads from, so archives are
+only shown for migrations started with gh blob migrate on this machine.`,
+		Example: `gh blob migration-status --org my-org
+gh blob migration-status --org my-org --migration-id RM_kgDO...
+gh blob migration-status --org my-org --active --output json`,
+		Args: cobra.NoArgs,
+		RunE: showMigrationStatus,
+	}
+	cmd.Flags().StringP("org", "o", "", "Organization the migrations belong to")
+	cmd.Flags().StringP("migration-id", "m", "", "Only show this migration")
+	cmd.Flags().Bool("active", false, "Only show queued and in-progress migrations")
+	addOutputFlag(cmd, "table", "json")
+	if err := cmd.MarkFlagRequired("org"); err != nil {
+		ghlog.Logger.Error("failed to mark flag as required", zap.Error(err))
+		return nil
+	}
+	return cmd
+}
+
+// migrationStatuses returns the org's migrations, or only the migration
+// with the given ID, and the archives each one references.
+func migrationStatuses(org string, migrationId string) ([]migrationStatus, error) {
+	var migrations []github.RepositoryMigration
+	if migrationId != "" {
+		migration, err := github.GetRepositoryMigration(migrationId)
+		if errors.Is(err, github.ErrMigrationNotFound) {
+			return nil, fmt.Errorf("migration %s not found", migrationId)
+		}
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, *migration)
+	} else {
+		var err error
+		migrations, err = github.ListRepositoryMigrations(org)
+		if err != nil {
+			return nil, err
+		}
+	}
+	archives, _, err := github.ListMigrationArchives(org, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archives of %s: %w", org, err)
+	}
+
+	uris, err := migrationArchiveUris(org)
+	if err != nil {
+		return nil, err
+	}
+	byMigration := github.ArchivesByMigration(migrations, archives, uris)
+	statuses := make([]migrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		statuses = append(statuses, migrationStatus{
+			RepositoryMigration: migration,
+			Active:              github.IsActiveMigrationState(migration.State),
+			Archives:            byMigration[migration.ID],
+		})
+	}
+	return statuses, nil
+}
+
+// migrationArchiveUris returns the archive URIs recorded in the local
+// inventory for the migrations of org started from this machine.
+func migrationArchiveUris(org string) (map[string][]string, error) {
+	store, err := inventory.OpenDefault()
+	if err != nil {
+		return nil, err
+	}
+	return store.MigrationArchives(defaultHost(), org), nil
+}
+
+func showMigrationStatus(cmd *cobra.Command, args []string) error {
+	org, _ := cmd.Flags().GetString("org")
+	migrationId, _ := cmd.Flags().GetString("migration-id")
+	activeOnly, _ := cmd.Flags().GetBool("active")
+	output, _ := cmd.Flags().GetString("output")
+
+	statuses, err := migrationStatuses(org, migrationId)
+	if err != nil {
+		return err
+	}
+
+	var selected []migrationStatus
+	for _, status := range statuses {
+		if activeOnly && !status.Active {
+			continue
+		}
+		selected = append(selected, status)
+	}
+
+	out := cmd.OutOrStdout()
+	switch output {
+	case "json":
+		if selected == nil {
+			selected = []migrationStatus{}
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(selected); err != nil {
+			return err
+		}
+	case "table":
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tREPOSITORY\tSTATE\tCREATED\tARCHIVES")
+		for _, status := range selected {
+			names := make([]string, 0, len(status.Archives))
+			for _, archive := range status.Archives {
+				names = append(names, archive.Name+" ("+archive.ID+")")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", status.ID, status.RepositoryName, status.State, status.CreatedAt, strings.Join(names, ", "))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid output format %q: must be table or json", output)
+	}
+
+	referenced := 0
+	for _, status := range selected {
+		if status.Active {
+			referenced += len(status.Archives)
+		}
+	}
+	ghlog.Logger.Info("Archives referenced by in-flight migrations",
+		zap.String("org", org),
+		zap.Int("count", referenced))
+	return nil
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/archive"
+	"github.com/robandpdx/gh-blob/internal/github"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+const (
+	conflictSkip    = "skip"
+	conflictReplace = "replace"
+	conflictRename  = "rename"
+	conflictError   = "error"
+)
+
+// addNamingFlags adds the flags choosing the blob name of an upload.
+func addNamingFlags(cmd *cobra.Command) {
+	cmd.Flags().String("name", "", "Blob name, or a template such as '{{.Repo}}-{{.Date}}-git.tar.gz' (fields: Org, Repo, File, Stem, Date, Time)")
+	cmd.Flags().String("on-conflict", "", "When an archive with the same name exists in the org: skip, replace, rename or error; not checked when empty")
+}
+
+// blobNamer picks the name of each archive uploaded by a command and applies
+// the --on-conflict policy against the archives already in the org. It is
+// safe for concurrent uploads, which never get the same name under the
+// rename policy.
+type blobNamer struct {
+	org      string
+	name     string
+	template *archive.NameTemplate
+	policy   string
+	now      time.Time
+	// compression is the --compress algorithm, whose extension a name
+	// from --name keeps
+	compression string
+
+	mu       sync.Mutex
+	existing map[string][]github.MigrationArchive
+	reserved map[string]bool
+}
+
+// newBlobNamer reads --name and --on-conflict for a command uploading count
+// archives to org.
+func newBlobNamer(cmd *cobra.Command, org string, count int) (*blobNamer, error) {
+	name, _ := cmd.Flags().GetString("name")
+	policy, _ := cmd.Flags().GetString("on-conflict")
+
+	switch policy {
+	case "", conflictSkip, conflictReplace, conflictRename, conflictError:
+	default:
+		return nil, fmt.Errorf("invalid --on-conflict %q: must be skip, replace, rename or error", policy)
+	}
+
+	// Not every command uploading archives can compress them
+	compression, _ := cmd.Flags().GetString("compress")
+
+	n := &blobNamer{
+		org:         org,
+		policy:      policy,
+		now:         time.Now(),
+		compression: compression,
+		reserved:    map[string]bool{},
+	}
+	switch {
+	case name == "":
+	case archive.IsNameTemplate(name):
+		tmpl, err := archive.ParseNameTemplate(name)
+		if err != nil {
+			return nil, err
+		}
+		n.template = tmpl
+	case count > 1:
+		return nil, fmt.Errorf("--name must be a template such as '{{.Stem}}-{{.Date}}.tar.gz' when uploading several archives")
+	default:
+		if err := archive.ValidateName(name); err != nil {
+			return nil, err
+		}
+		n.name = name
+	}
+	return n, nil
+}
+
+// resolve sets input.Name to the blob name of the upload. repo names the
+// repository for templates and defaults to the stem of the file name. If
+// the policy is skip and an archive with the name exists, that archive is
+// returned and nothing should be uploaded. Under the replace policy the
+// archives to delete once the upload succeeds are returned.
+func (n *blobNamer) resolve(input *github.UploadArchiveInput, repo string) (*github.UploadArchiveResponse, []github.MigrationArchive, error) {
+	name := input.Name
+	if name == "" {
+		name = filepath.Base(input.ArchiveFilePath)
+	}
+	switch {
+	case n.template != nil:
+		var err error
+		name, err = n.template.Render(archive.NewNameData(n.org, name, repo, n.now))
+		if err != nil {
+			return nil, nil, err
+		}
+	case n.name != "":
+		name = n.name
+	}
+	if (n.template != nil || n.name != "") && n.compression != "" {
+		name = archive.WithCompressedExt(name, n.compression)
+	}
+	input.Name = name
+	if n.policy == "" {
+		return nil, nil, nil
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.existing == nil {
+		archives, _, err := github.ListMigrationArchives(n.org, "")
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list archives of %s to check for name conflicts: %w", n.org, err)
+		}
+		n.existing = map[string][]github.MigrationArchive{}
+		for _, a := range archives {
+			n.existing[a.Name] = append(n.existing[a.Name], a)
+		}
+	}
+
+	conflicts := n.existing[name]
+	var replaced []github.MigrationArchive
+	if n.reserved[name] && n.policy != conflictRename {
+		return nil, nil, fmt.Errorf("more than one archive of this upload is named %q", name)
+	}
+
+	switch n.policy {
+	case conflictError:
+		if len(conflicts) > 0 {
+			return nil, nil, fmt.Errorf("an archive named %q already exists in %s (ID %s), use --on-conflict to choose what to do", name, n.org, conflicts[0].ID)
+		}
+	case conflictSkip:
+		if len(conflicts) > 0 {
+			latest := latestArchive(conflicts)
+			ghlog.Logger.Info("Archive with the same name exists, skipping upload",
+				zap.String("name", name),
+				zap.String("id", latest.ID))
+			n.reserved[name] = true
+			return &github.UploadArchiveResponse{
+				GUID:      latest.GUID,
+				NodeID:    latest.ID,
+				Name:      latest.Name,
+				Size:      latest.Size,
+				URI:       latest.URI,
+				CreatedAt: latest.CreatedAt,
+			}, nil, nil
+		}
+	case conflictRename:
+		stem, ext := archive.SplitName(name)
+		for i := 1; len(n.existing[name]) > 0 || n.reserved[name]; i++ {
+			name = fmt.Sprintf("%s-%d%s", stem, i, ext)
+		}
+		if name != input.Name {
+			ghlog.Logger.Info("Archive with the same name exists, renaming upload",
+				zap.String("name", input.Name),
+				zap.String("newName", name))
+		}
+		input.Name = name
+	case conflictReplace:
+		// The previous archives are deleted by the caller after the upload
+		// succeeds, so a failed upload keeps them
+		replaced = conflicts
+	}
+	n.reserved[name] = true
+	return nil, replaced, nil
+}
+
+// replaceArchives deletes the archives an upload replaced. Archives still
+// used by a migration are kept, as is the uploaded archive itself, which
+// --dedupe may have reused from among the replaced ones.
+func replaceArchives(cmd *cobra.Command, org string, uploaded *github.UploadArchiveResponse, replaced []github.MigrationArchive) error {
+	var errs []error
+	for _, a := range replaced {
+		if a.ID == uploaded.NodeID {
+			continue
+		}
+		ghlog.Logger.Info("Deleting replaced archive",
+			zap.String("name", a.Name),
+			zap.String("id", a.ID))
+		if err := deleteArchive(cmd, org, a.ID, false); err != nil {
+			errs = append(errs, fmt.Errorf("failed to replace archive %s: %w", a.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func latestArchive(archives []github.MigrationArchive) github.MigrationArchive {
+	sorted := append([]github.MigrationArchive(nil), archives...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt > sorted[j].CreatedAt
+	})
+	return sorted[0]
+}
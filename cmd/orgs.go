@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/github"
+	"github.com/robandpdx/gh-blob/internal/inventory"
+	"github.com/robandpdx/gh-blob/internal/labels"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+// orgArchive is a migration archive tagged with the organization it belongs
+// to, used when listing archives across several organizations.
+type orgArchive struct {
+	Org string `json:"org"`
+	github.MigrationArchive
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// mergeArchives flattens archives of several orgs into one list ordered by
+// org and creation time.
+func mergeArchives(archivesByOrg map[string][]github.MigrationArchive) []orgArchive {
+	var merged []orgArchive
+	for org, archives := range archivesByOrg {
+		for _, archive := range archives {
+			merged = append(merged, orgArchive{Org: org, MigrationArchive: archive})
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].Org != merged[j].Org {
+			return merged[i].Org < merged[j].Org
+		}
+		return merged[i].CreatedAt < merged[j].CreatedAt
+	})
+	return merged
+}
+
+// printArchives writes archives in the given output format: "log" emits the
+// same log lines as query-all always has, "json" a JSON array and "table" a
+// table on stdout.
+func printArchives(w io.Writer, archives []orgArchive, output string) error {
+	switch output {
+	case "log":
+		for _, blob := range archives {
+			ghlog.Logger.Info("Blob Org: " + blob.Org)
+			ghlog.Logger.Info("Blob ID: " + blob.ID)
+			ghlog.Logger.Info("Blob GUID: " + blob.GUID)
+			ghlog.Logger.Info("Blob Name: " + blob.Name)
+			ghlog.Logger.Info("Blob Size: " + fmt.Sprintf("%d", blob.Size))
+			ghlog.Logger.Info("Blob URI: " + blob.URI)
+			ghlog.Logger.Info("Blob Created At: " + blob.CreatedAt)
+			if len(blob.Labels) > 0 {
+				ghlog.Logger.Info("Blob Labels: " + labels.Format(blob.Labels))
+			}
+			ghlog.Logger.Info("==========================")
+		}
+		ghlog.Logger.Info("Total blobs: " + fmt.Sprintf("%d", len(archives)))
+	case "json":
+		if archives == nil {
+			archives = []orgArchive{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(archives)
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ORG\tNAME\tSIZE\tCREATED\tID\tGUID\tLABELS")
+		for _, blob := range archives {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", blob.Org, blob.Name, blob.Size, blob.CreatedAt, blob.ID, blob.GUID, labels.Format(blob.Labels))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("invalid output format %q: must be log, json or table", output)
+	}
+	return nil
+}
+
+// resolveOrgs returns the organizations to operate on: the given list, or
+// every organization in the enterprise when a slug is given.
+func resolveOrgs(orgs []string, enterprise string) ([]string, error) {
+	if enterprise == "" {
+		if len(orgs) == 0 {
+			return nil, fmt.Errorf("organization or enterprise is required")
+		}
+		return orgs, nil
+	}
+	if len(orgs) > 0 {
+		return nil, fmt.Errorf("--org and --enterprise cannot be used together")
+	}
+
+	logins, err := github.ListEnterpriseOrganizations(enterprise)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organizations of enterprise %s: %w", enterprise, err)
+	}
+	ghlog.Logger.Info("Resolved enterprise organizations",
+		zap.String("enterprise", enterprise),
+		zap.Int("count", len(logins)))
+	return logins, nil
+}
+
+// fetchArchives returns the migration archives of each org, either from the
+// local inventory (warning when older than maxAge) or live from GitHub with
+// up to concurrency orgs paged at once.
+func fetchArchives(orgs []string, cached bool, maxAge time.Duration, concurrency int) (map[string][]github.MigrationArchive, error) {
+	result := map[string][]github.MigrationArchive{}
+
+	if cached {
+		store, err := inventory.OpenDefault()
+		if err != nil {
+			return nil, err
+		}
+		for _, org := range orgs {
+			inv := store.Get(defaultHost(), org)
+			if inv == nil || inv.LastSynced.IsZero() {
+				return nil, fmt.Errorf("no inventory for %s, run 'gh blob inventory sync --org %s' first", org, org)
+			}
+			if inv.Stale(maxAge) {
+				ghlog.Logger.Warn("Inventory is stale, run 'gh blob inventory sync' to refresh it",
+					zap.String("org", org),
+					zap.Time("lastSynced", inv.LastSynced))
+			}
+			result[org] = inv.Archives
+		}
+		return result, nil
+	}
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+		sem  = make(chan struct{}, concurrency)
+	)
+	for _, org := range orgs {
+		wg.Add(1)
+		go func(org string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			archives, _, err := github.ListMigrationArchives(org, "")
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to list archives of %s: %w", org, err))
+				return
+			}
+			ghlog.Logger.Debug("Listed archives",
+				zap.String("org", org),
+				zap.Int("count", len(archives)))
+			result[org] = archives
+		}(org)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return result, nil
+}
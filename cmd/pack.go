@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/robandpdx/gh-blob/internal/archive"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+	"github.com/robandpdx/gh-blob/pkg/units"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func Pack() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pack",
+		Short: "Build a git archive from a local repository",
+		Long: `Build a git archive from a local repository.
+The archive holds a bare mirror of the repository: its branches (including
+origin's branches that have no local counterpart) and tags in packed-refs and
+every reachable object in a single pack. An --out ending in .gz or .tgz is
+gzip compressed, and - writes the archive to stdout.
+Use upload --from-git to upload the archive without writing it to disk.`,
+		Example: `gh blob pack --repo-path ./repo --out archive.tar.gz
+gh blob pack --repo-path ./repo --out - > archive.tar`,
+		Args: cobra.NoArgs,
+		RunE: packRepository,
+	}
+	cmd.Flags().String("repo-path", ".", "Path to the local repository")
+	cmd.Flags().String("out", "", "File to write the archive to, or - for stdout")
+	cmd.Flags().String("name", "", "Repository name used inside the archive (defaults to the directory name)")
+	err := cmd.MarkFlagRequired("out")
+	if err != nil {
+		ghlog.Logger.Error("failed to mark flag as required", zap.Error(err))
+		return nil
+	}
+	offline(cmd)
+	return cmd
+}
+
+func packRepository(cmd *cobra.Command, args []string) error {
+	repoPath, _ := cmd.Flags().GetString("repo-path")
+	out, _ := cmd.Flags().GetString("out")
+	name, _ := cmd.Flags().GetString("name")
+
+	pack, err := archive.NewGitPack(repoPath, name)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = cmd.OutOrStdout()
+	if out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", out, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var gz *gzip.Writer
+	if strings.HasSuffix(out, ".gz") || strings.HasSuffix(out, ".tgz") {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	if _, err := pack.WriteTo(w); err != nil {
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return fmt.Errorf("failed to compress archive: %w", err)
+		}
+	}
+
+	ghlog.Logger.Info("Packed git archive",
+		zap.String("repoPath", repoPath),
+		zap.String("out", out),
+		zap.Int("refs", pack.Refs),
+		zap.String("size", units.FormatBytes(pack.Size())))
+	return nil
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/robandpdx/gh-blob/internal/throttle"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+	"github.com/robandpdx/gh-blob/pkg/units"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// addThrottleFlags adds the bandwidth limit flags to a command that uploads.
+// Both are usually set in a config profile.
+func addThrottleFlags(cmd *cobra.Command) {
+	cmd.Flags().String("limit-rate", "", "Maximum upload rate (e.g. 50MB/s); unlimited when empty")
+	cmd.Flags().String("limit-rate-schedule", "", "Rates by local time of day overriding --limit-rate (e.g. 09:00-18:00=10MB/s,18:00-09:00=0)")
+}
+
+// newLimiter builds the limiter shared by every upload of the command from
+// the flags added with addThrottleFlags, or nil when uploads are unlimited.
+func newLimiter(cmd *cobra.Command) (*throttle.Limiter, error) {
+	rateValue, _ := cmd.Flags().GetString("limit-rate")
+	scheduleValue, _ := cmd.Flags().GetString("limit-rate-schedule")
+
+	var bps int64
+	if rateValue != "" {
+		var err error
+		bps, err = units.ParseRate(rateValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --limit-rate: %w", err)
+		}
+	}
+	schedule, err := throttle.ParseSchedule(scheduleValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --limit-rate-schedule: %w", err)
+	}
+
+	limiter := throttle.New(bps, schedule)
+	if limiter != nil {
+		ghlog.Logger.Info("Limiting upload rate",
+			zap.String("limitRate", rateValue),
+			zap.String("schedule", scheduleValue))
+	}
+	return limiter, nil
+}
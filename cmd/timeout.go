@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/throttle"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+	"github.com/robandpdx/gh-blob/pkg/units"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// addPartTimeoutFlags adds the per-request timeouts of multipart uploads to a
+// command that uploads.
+func addPartTimeoutFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("part-timeout", 30*time.Minute, "Timeout for uploading each part, which is then retried; 0 for no limit")
+	cmd.Flags().Duration("stall-timeout", 2*time.Minute, "Abort and retry a request when no bytes move for this long; 0 to disable")
+}
+
+// timeoutContext returns the command context bounded by --timeout, where 0
+// means no limit.
+func timeoutContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	if timeout <= 0 {
+		return context.WithCancel(cmd.Context())
+	}
+	return context.WithTimeout(cmd.Context(), timeout)
+}
+
+// partTimeout returns --part-timeout for parts of partSize bytes sent through
+// limiter by concurrent uploads sharing it. When the lowest rate of the
+// limiter could not send a part in time, the default is raised to twice the
+// time a part needs, while a timeout given on the command line is an error.
+func partTimeout(cmd *cobra.Command, partSize int64, limiter *throttle.Limiter, concurrent int) (time.Duration, error) {
+	timeout, _ := cmd.Flags().GetDuration("part-timeout")
+	bps := limiter.MinRate()
+	if timeout <= 0 || bps <= 0 {
+		return timeout, nil
+	}
+	if concurrent < 1 {
+		concurrent = 1
+	}
+	needed := time.Duration(float64(partSize) * float64(concurrent) / float64(bps) * float64(time.Second))
+	if needed < timeout {
+		return timeout, nil
+	}
+	if cmd.Flags().Changed("part-timeout") {
+		return 0, fmt.Errorf("--part-timeout %s is too short to send a %s part at %s/s, which takes %s; raise it, lower --part-size or use 0 for no limit",
+			timeout, units.FormatBytes(partSize), units.FormatBytes(bps/int64(concurrent)), needed.Round(time.Second))
+	}
+	timeout = (2 * needed).Round(time.Minute)
+	ghlog.Logger.Info("Raised the part timeout so parts can finish at the limited rate",
+		zap.Duration("partTimeout", timeout))
+	return timeout, nil
+}
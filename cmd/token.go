@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// offlineAnnotation marks a command that only reads local files and so runs
+// without GITHUB_TOKEN. Its value names a boolean flag the command is only
+// offline with, or is empty when the command never calls the API.
+const offlineAnnotation = "gh-blob_offline"
+
+// offline marks cmd as never calling the GitHub API.
+func offline(cmd *cobra.Command) {
+	offlineWith(cmd, "")
+}
+
+// offlineWith marks cmd as not calling the GitHub API when the boolean flag
+// name is set.
+func offlineWith(cmd *cobra.Command, name string) {
+	if cmd.Annotations == nil {
+		cmd.Annotations = map[string]string{}
+	}
+	cmd.Annotations[offlineAnnotation] = name
+}
+
+// RequireToken fails unless GITHUB_TOKEN is set or cmd runs offline.
+func RequireToken(cmd *cobra.Command) error {
+	if os.Getenv("GITHUB_TOKEN") != "" {
+		return nil
+	}
+	if name, ok := cmd.Annotations[offlineAnnotation]; ok {
+		if name == "" {
+			return nil
+		}
+		if set, _ := cmd.Flags().GetBool(name); set {
+			return nil
+		}
+	}
+	// The root command is named "gh blob", which CommandPath cuts to "gh"
+	path := strings.Replace(cmd.CommandPath(), cmd.Root().Name(), cmd.Root().Use, 1)
+	return fmt.Errorf("GITHUB_TOKEN must be set to run %s", path)
+}
@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/audit"
+	"github.com/robandpdx/gh-blob/internal/github"
+	"github.com/robandpdx/gh-blob/internal/inventory"
+	"github.com/robandpdx/gh-blob/internal/trash"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// softDeleteArchive moves an archive to the local trash. The archive stays on
+// GitHub until trash purge runs after the retention period.
+func softDeleteArchive(cmd *cobra.Command, org string, id string, retention time.Duration) error {
+	record := audit.Record{
+		Org:       org,
+		Operation: audit.OperationSoftDelete,
+		BlobID:    id,
+	}
+
+	blob, err := github.QueryBlobFromGitHub(id)
+	if err != nil {
+		record.Outcome = audit.OutcomeFailure
+		record.Error = err.Error()
+		recordAudit(cmd, record)
+		return fmt.Errorf("failed to look up blob %s: %w", id, err)
+	}
+	archive := blob.Node.MigrationArchive
+	record.GUID = archive.GUID
+	record.Name = archive.Name
+	record.Size = int64(archive.Size)
+
+	if org == "" {
+		if store, err := inventory.OpenDefault(); err == nil {
+			if inv, ok := store.FindByID(id); ok {
+				org = inv.Org
+				record.Org = org
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	entry := trash.Entry{
+		ID:         id,
+		GUID:       archive.GUID,
+		Name:       archive.Name,
+		Size:       archive.Size,
+		URI:        archive.URI,
+		Org:        org,
+		Host:       defaultHost(),
+		TrashedAt:  now,
+		PurgeAfter: now.Add(retention),
+	}
+
+	manifest, err := trash.OpenDefault()
+	if err == nil {
+		err = manifest.Add(entry)
+	}
+	if err == nil {
+		err = manifest.Save()
+	}
+	if err != nil {
+		record.Outcome = audit.OutcomeFailure
+		record.Error = err.Error()
+		recordAudit(cmd, record)
+		return err
+	}
+	record.Outcome = audit.OutcomeSuccess
+	recordAudit(cmd, record)
+
+	ghlog.Logger.Info("Moved blob to trash",
+		zap.String("id", id),
+		zap.String("name", entry.Name),
+		zap.Time("purgeAfter", entry.PurgeAfter))
+	return nil
+}
+
+func TrashCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trash",
+		Short: "Manage soft deleted blobs",
+		Long: `Manage soft deleted blobs.
+delete --soft moves a blob to the local trash instead of deleting it. The blob
+stays on GitHub until trash purge deletes it after its retention period, so
+mistakes can be undone with trash restore.`,
+	}
+	cmd.AddCommand(trashList(), trashRestore(), trashPurge())
+	return cmd
+}
+
+func trashList() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list",
+		Short:   "List soft deleted blobs",
+		Example: `gh blob trash list --output json`,
+		Args:    cobra.NoArgs,
+		RunE:    listTrash,
+	}
+	addOutputFlag(cmd, "table", "json")
+	offline(cmd)
+	return cmd
+}
+
+func listTrash(cmd *cobra.Command, args []string) error {
+	manifest, err := trash.OpenDefault()
+	if err != nil {
+		return err
+	}
+
+	output, _ := cmd.Flags().GetString("output")
+	out := cmd.OutOrStdout()
+	switch output {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		entries := manifest.Entries
+		if entries == nil {
+			entries = []trash.Entry{}
+		}
+		return enc.Encode(entries)
+	case "table":
+		now := time.Now()
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ORG\tNAME\tID\tTRASHED\tPURGE AFTER\tEXPIRED")
+		for _, e := range manifest.Entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%t\n",
+				e.Org, e.Name, e.ID, e.TrashedAt.Format(time.RFC3339), e.PurgeAfter.Format(time.RFC3339), e.Expired(now))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("invalid output format %q: must be table or json", output)
+	}
+}
+
+func trashRestore() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Take a blob out of the trash so it is not purged",
+		Example: `gh blob trash restore --id <blob-id>
+gh blob trash restore --name <file-name>`,
+		Args: cobra.NoArgs,
+		RunE: restoreTrash,
+	}
+	addTrashSelectorFlags(cmd, "restore")
+	cmd.MarkFlagsOneRequired("id", "guid", "name", "uri")
+	return cmd
+}
+
+// addTrashSelectorFlags adds --id and the alternative --guid, --name and
+// --uri, which are matched against the blobs in the trash.
+func addTrashSelectorFlags(cmd *cobra.Command, action string) {
+	cmd.Flags().StringP("id", "i", "", "ID of the blob to "+action)
+	cmd.Flags().String("guid", "", "GUID of the blob to "+action)
+	cmd.Flags().String("name", "", "File name of the blob to "+action)
+	cmd.Flags().String("uri", "", "URI (gei://archive/...) of the blob to "+action)
+	cmd.MarkFlagsMutuallyExclusive("id", "guid", "name", "uri")
+}
+
+// resolveTrashID returns the ID selected by the flags added with
+// addTrashSelectorFlags, or an empty string when none of them is set. A
+// GUID, name or URI must match exactly one blob in the trash.
+func resolveTrashID(cmd *cobra.Command, manifest *trash.Manifest) (string, error) {
+	if id, _ := cmd.Flags().GetString("id"); id != "" {
+		return id, nil
+	}
+	selector := github.ArchiveSelector{}
+	selector.GUID, _ = cmd.Flags().GetString("guid")
+	selector.Name, _ = cmd.Flags().GetString("name")
+	selector.URI, _ = cmd.Flags().GetString("uri")
+	if selector.IsZero() {
+		return "", nil
+	}
+
+	var matches []trash.Entry
+	for _, entry := range manifest.Entries {
+		if selector.Matches(github.MigrationArchive{GUID: entry.GUID, Name: entry.Name, URI: entry.URI}) {
+			matches = append(matches, entry)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no blob with %s in the trash", selector)
+	case 1:
+		return matches[0].ID, nil
+	}
+	candidates := make([]string, 0, len(matches))
+	for _, entry := range matches {
+		candidates = append(candidates, fmt.Sprintf("%s (%s)", entry.ID, entry.Org))
+	}
+	return "", fmt.Errorf("%d blobs with %s in the trash, use --id with one of: %s",
+		len(matches), selector, strings.Join(candidates, ", "))
+}
+
+func restoreTrash(cmd *cobra.Command, args []string) error {
+	manifest, err := trash.OpenDefault()
+	if err != nil {
+		return err
+	}
+	id, err := resolveTrashID(cmd, manifest)
+	if err != nil {
+		return err
+	}
+	entry, ok := manifest.Find(id)
+	if !ok {
+		return fmt.Errorf("blob %s is not in the trash", id)
+	}
+	manifest.Remove(id)
+	if err := manifest.Save(); err != nil {
+		return err
+	}
+
+	recordAudit(cmd, audit.Record{
+		Org:       entry.Org,
+		Operation: audit.OperationRestore,
+		BlobID:    entry.ID,
+		GUID:      entry.GUID,
+		Name:      entry.Name,
+		Size:      int64(entry.Size),
+		Outcome:   audit.OutcomeSuccess,
+	})
+	ghlog.Logger.Info("Restored blob from trash",
+		zap.String("id", id),
+		zap.String("name", entry.Name))
+	return nil
+}
+
+func trashPurge() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "purge",
+		Short: "Delete soft deleted blobs whose retention period is over",
+		Long: `Delete soft deleted blobs whose retention period is over.
+Blobs that fail to delete stay in the trash so purge can be run again.`,
+		Example: `gh blob trash purge
+gh blob trash purge --id <blob-id> --all
+gh blob trash purge --guid <guid> --all
+gh blob trash purge --all --force`,
+		Args: cobra.NoArgs,
+		RunE: purgeTrash,
+	}
+	addTrashSelectorFlags(cmd, "purge")
+	cmd.Flags().Bool("all", false, "Purge blobs even if their retention period is not over")
+	cmd.Flags().Bool("force", false, "Delete even if a blob is used by a queued or in-progress migration")
+	return cmd
+}
+
+func purgeTrash(cmd *cobra.Command, args []string) error {
+	all, _ := cmd.Flags().GetBool("all")
+	force, _ := cmd.Flags().GetBool("force")
+
+	manifest, err := trash.OpenDefault()
+	if err != nil {
+		return err
+	}
+	id, err := resolveTrashID(cmd, manifest)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var due []trash.Entry
+	for _, entry := range manifest.Entries {
+		if id != "" && entry.ID != id {
+			continue
+		}
+		if all || entry.Expired(now) {
+			due = append(due, entry)
+		}
+	}
+	if id != "" && len(due) == 0 {
+		if entry, ok := manifest.Find(id); ok {
+			return fmt.Errorf("blob %s is not due for purge until %s, use --all to purge it now", id, entry.PurgeAfter.Format(time.RFC3339))
+		}
+		return fmt.Errorf("blob %s is not in the trash", id)
+	}
+
+	var errs []error
+	purged := 0
+	for _, entry := range due {
+		err := deleteArchive(cmd, entry.Org, entry.ID, force)
+		if errors.Is(err, github.ErrBlobNotFound) {
+			// Already gone from GitHub, which is what purging is for
+			ghlog.Logger.Info("Blob in the trash no longer exists, removing it from the trash",
+				zap.String("id", entry.ID),
+				zap.String("name", entry.Name))
+			updateInventory(func(store *inventory.Store) {
+				store.RemoveByID(entry.ID)
+			})
+			err = nil
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.ID, err))
+			continue
+		}
+		manifest.Remove(entry.ID)
+		purged++
+	}
+	if purged > 0 {
+		if err := manifest.Save(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	ghlog.Logger.Info("Purged trash",
+		zap.Int("purged", purged),
+		zap.Int("failed", len(due)-purged),
+		zap.Int("remaining", len(manifest.Entries)))
+	return errors.Join(errs...)
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/inventory"
+	"github.com/robandpdx/gh-blob/internal/usage"
+
+	"github.com/spf13/cobra"
+)
+
+func Usage() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "usage",
+		Short: "Report migration archive storage usage",
+		Long: `Report migration archive storage usage.
+Shows the total count and size of archives, a size histogram, age buckets,
+the largest archives and growth per month for one or more organizations.`,
+		Example: `gh blob usage --org my-org
+gh blob usage --org org-a,org-b --output markdown
+gh blob usage --enterprise my-enterprise --output json`,
+		Args: cobra.NoArgs,
+		RunE: reportUsage,
+	}
+	cmd.Flags().StringSliceP("org", "o", nil, "Organizations to report on (repeat or comma separate)")
+	cmd.Flags().StringP("enterprise", "e", "", "Report on every organization in this enterprise")
+	cmd.Flags().Int("top", 10, "Number of largest archives to list")
+	cmd.Flags().Bool("cached", false, "Read from the local inventory instead of GitHub")
+	cmd.Flags().Int("concurrency", 4, "Number of organizations to query at once")
+	addOutputFlag(cmd, "table", "json", "markdown")
+	cmd.MarkFlagsMutuallyExclusive("org", "enterprise")
+	return cmd
+}
+
+func reportUsage(cmd *cobra.Command, args []string) error {
+	orgs, _ := cmd.Flags().GetStringSlice("org")
+	enterprise, _ := cmd.Flags().GetString("enterprise")
+	top, _ := cmd.Flags().GetInt("top")
+	cached, _ := cmd.Flags().GetBool("cached")
+	output, _ := cmd.Flags().GetString("output")
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+
+	if top < 0 {
+		return fmt.Errorf("--top must not be negative")
+	}
+
+	orgs, err := resolveOrgs(orgs, enterprise)
+	if err != nil {
+		return err
+	}
+
+	archivesByOrg, err := fetchArchives(orgs, cached, inventory.DefaultMaxAge, concurrency)
+	if err != nil {
+		return err
+	}
+
+	report := usage.NewReport(archivesByOrg, time.Now(), top)
+	return usage.Render(cmd.OutOrStdout(), report, output)
+}
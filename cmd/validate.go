@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/robandpdx/gh-blob/internal/archive"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+	"github.com/robandpdx/gh-blob/pkg/units"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func Validate() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check that a migration archive is usable before uploading it",
+		Long: `Check that a migration archive is usable before uploading it.
+The archive must be a readable tar or tar.gz file within the size limit of
+GitHub-owned storage. A git archive must contain a bare repository and a
+metadata archive the JSON files of a migration export. Without --archive-type
+the kind of archive is detected.`,
+		Example: `gh blob validate -a migration-archive.tar.gz
+gh blob validate -a metadata-archive.tar.gz --archive-type metadata --output json`,
+		Args: cobra.NoArgs,
+		RunE: validateBlob,
+	}
+	cmd.Flags().StringP("archive-file-path", "a", "", "Path to the archive")
+	cmd.Flags().String("archive-type", "", "Expected kind of archive: git or metadata")
+	addOutputFlag(cmd, "log", "json")
+	err := cmd.MarkFlagRequired("archive-file-path")
+	if err != nil {
+		ghlog.Logger.Error("failed to mark flag as required", zap.Error(err))
+		return nil
+	}
+	offline(cmd)
+	return cmd
+}
+
+func validateBlob(cmd *cobra.Command, args []string) error {
+	archiveFilePath, _ := cmd.Flags().GetString("archive-file-path")
+	archiveType, _ := cmd.Flags().GetString("archive-type")
+	output, _ := cmd.Flags().GetString("output")
+
+	report, err := archive.Validate(archiveFilePath, archiveType)
+	if err != nil {
+		return err
+	}
+
+	switch output {
+	case "json":
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	case "log":
+		logValidationReport(report)
+	default:
+		return fmt.Errorf("invalid output format %q: must be log or json", output)
+	}
+
+	if !report.Valid() {
+		return fmt.Errorf("archive %s is invalid", archiveFilePath)
+	}
+	return nil
+}
+
+// validateArchive validates the archive at path before an upload and returns
+// an error if it is unusable, so no bytes are sent to GitHub.
+func validateArchive(path string, archiveType string) error {
+	report, err := archive.Validate(path, archiveType)
+	if err != nil {
+		return err
+	}
+	logValidationReport(report)
+	if !report.Valid() {
+		return fmt.Errorf("archive %s is invalid, not uploading it", path)
+	}
+	return nil
+}
+
+func logValidationReport(report *archive.Report) {
+	log := ghlog.Logger.With(zap.String("path", report.Path))
+	for _, problem := range report.Problems {
+		log.Error("Archive problem: " + problem)
+	}
+	for _, warning := range report.Warnings {
+		log.Warn("Archive warning: " + warning)
+	}
+	if report.Valid() {
+		log.Info("Archive is valid",
+			zap.String("type", report.Type),
+			zap.Bool("compressed", report.Compressed),
+			zap.String("size", units.FormatBytes(report.Size)),
+			zap.Int("entries", report.Entries))
+	}
+}
@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/labels"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+const (
+	eventAdded   = "added"
+	eventRemoved = "removed"
+)
+
+// archiveEvent is an archive that appeared or disappeared between two polls
+// of query-all --watch.
+type archiveEvent struct {
+	Time  time.Time `json:"time"`
+	Event string    `json:"event"`
+	orgArchive
+}
+
+// diffArchives returns the archives added and removed between two snapshots,
+// matched by ID, as events at now.
+func diffArchives(previous, current []orgArchive, now time.Time) []archiveEvent {
+	before := map[string]bool{}
+	for _, archive := range previous {
+		before[archive.ID] = true
+	}
+	after := map[string]bool{}
+	for _, archive := range current {
+		after[archive.ID] = true
+	}
+
+	var events []archiveEvent
+	for _, archive := range current {
+		if !before[archive.ID] {
+			events = append(events, archiveEvent{Time: now, Event: eventAdded, orgArchive: archive})
+		}
+	}
+	for _, archive := range previous {
+		if !after[archive.ID] {
+			events = append(events, archiveEvent{Time: now, Event: eventRemoved, orgArchive: archive})
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].Org != events[j].Org {
+			return events[i].Org < events[j].Org
+		}
+		return events[i].CreatedAt < events[j].CreatedAt
+	})
+	return events
+}
+
+// printEvents writes events as log lines, or as one JSON object per line for
+// the json output.
+func printEvents(w io.Writer, events []archiveEvent, output string) error {
+	for _, event := range events {
+		if output == "json" {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return fmt.Errorf("failed to encode event: %w", err)
+			}
+			if _, err := fmt.Fprintln(w, string(data)); err != nil {
+				return err
+			}
+			continue
+		}
+		fields := []zap.Field{
+			zap.Time("time", event.Time),
+			zap.String("org", event.Org),
+			zap.String("name", event.Name),
+			zap.Int("size", event.Size),
+			zap.String("id", event.ID),
+			zap.String("guid", event.GUID),
+		}
+		if len(event.Labels) > 0 {
+			fields = append(fields, zap.String("labels", labels.Format(event.Labels)))
+		}
+		if event.Event == eventAdded {
+			ghlog.Logger.Info("Archive added", fields...)
+		} else {
+			ghlog.Logger.Info("Archive removed", fields...)
+		}
+	}
+	return nil
+}
+
+// watchArchives polls the archives of orgs every interval until the command
+// is interrupted, printing only the archives added or removed since the
+// previous poll. A failed poll is logged and retried at the next interval.
+func watchArchives(cmd *cobra.Command, orgs []string, selector map[string]string, output string, interval time.Duration, concurrency int) error {
+	if output != "log" && output != "json" {
+		return fmt.Errorf("invalid output format %q for --watch: must be log or json", output)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	poll := func() ([]orgArchive, error) {
+		archivesByOrg, err := fetchArchives(orgs, false, 0, concurrency)
+		if err != nil {
+			return nil, err
+		}
+		return withLabels(mergeArchives(archivesByOrg), selector)
+	}
+
+	snapshot, err := poll()
+	if err != nil {
+		return fmt.Errorf("failed to query blobs: %w", err)
+	}
+	ghlog.Logger.Info("Watching for archive changes, press Ctrl-C to stop",
+		zap.Int("organizations", len(orgs)),
+		zap.Int("archives", len(snapshot)),
+		zap.Duration("interval", interval))
+
+	ctx := cmd.Context()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			ghlog.Logger.Info("Stopped watching")
+			return nil
+		case <-ticker.C:
+		}
+
+		current, err := poll()
+		if err != nil {
+			ghlog.Logger.Warn("failed to query blobs, retrying at the next interval", zap.Error(err))
+			continue
+		}
+		events := diffArchives(snapshot, current, time.Now().UTC())
+		if err := printEvents(cmd.OutOrStdout(), events, output); err != nil {
+			return err
+		}
+		ghlog.Logger.Debug("Polled archives",
+			zap.Int("archives", len(current)),
+			zap.Int("changes", len(events)))
+		snapshot = current
+	}
+}
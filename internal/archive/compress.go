@@ -0,0 +1,200 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// DetectCompression returns the compression of data from its magic bytes,
+// or an empty string if it is not gzip or zstd compressed.
+func DetectCompression(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		return CompressionGzip
+	case bytes.HasPrefix(data, zstdMagic):
+		return CompressionZstd
+	}
+	return ""
+}
+
+// DetectFileCompression returns the compression of the file at path.
+func DetectFileCompression(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(zstdMagic))
+	n, err := io.ReadFull(f, magic)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	return DetectCompression(magic[:n]), nil
+}
+
+// CompressedName returns name with the file extension of algorithm appended.
+func CompressedName(name string, algorithm string) string {
+	switch algorithm {
+	case CompressionGzip:
+		return name + ".gz"
+	case CompressionZstd:
+		return name + ".zst"
+	}
+	return name
+}
+
+// WithCompressedExt returns name unchanged if it already has the file
+// extension of algorithm, and CompressedName otherwise.
+func WithCompressedExt(name string, algorithm string) string {
+	lower := strings.ToLower(name)
+	switch algorithm {
+	case CompressionGzip:
+		if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+			return name
+		}
+	case CompressionZstd:
+		if strings.HasSuffix(lower, ".zst") {
+			return name
+		}
+	}
+	return CompressedName(name, algorithm)
+}
+
+// NewCompressor returns a writer compressing into w with algorithm.
+func NewCompressor(w io.Writer, algorithm string) (io.WriteCloser, error) {
+	switch algorithm {
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("invalid compression %q: must be gzip or zstd", algorithm)
+}
+
+// Compress returns a stream of r compressed with algorithm. The compressed
+// size is not known until the stream ends. Closing the stream stops the
+// compression.
+func Compress(r io.Reader, algorithm string) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+	// Buffer the pipe so the compressor is not stalled by every small read
+	bw := bufio.NewWriterSize(pw, 1024*1024)
+	compressor, err := NewCompressor(bw, algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		_, err := io.Copy(compressor, r)
+		if closeErr := compressor.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = bw.Flush()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// MeasureCompressed compresses r with algorithm into nothing and returns the
+// size and SHA-256 of the compressed data, for a stream that has to be
+// compressed again to be uploaded at a size known in advance.
+func MeasureCompressed(r io.Reader, algorithm string) (int64, []byte, error) {
+	sum := sha256.New()
+	counter := &countingWriter{w: sum}
+	compressor, err := NewCompressor(counter, algorithm)
+	if err != nil {
+		return 0, nil, err
+	}
+	if _, err := io.Copy(compressor, r); err != nil {
+		return 0, nil, fmt.Errorf("failed to compress archive: %w", err)
+	}
+	if err := compressor.Close(); err != nil {
+		return 0, nil, fmt.Errorf("failed to compress archive: %w", err)
+	}
+	return counter.n, sum.Sum(nil), nil
+}
+
+// errCompressionChanged reports a stream that did not compress to what
+// MeasureCompressed measured.
+var errCompressionChanged = errors.New("archive compressed differently the second time, it changed while it was uploaded")
+
+// Expect returns a reader of r, which must yield exactly size bytes with the
+// given SHA-256. The read that would complete a stream that does not match
+// fails instead of returning its bytes, so a wrong stream is never fully
+// uploaded.
+func Expect(r io.Reader, size int64, sum []byte) io.Reader {
+	return &expectReader{r: r, remaining: size, sum: sum, hash: sha256.New()}
+}
+
+type expectReader struct {
+	r         io.Reader
+	remaining int64
+	sum       []byte
+	hash      hash.Hash
+}
+
+func (e *expectReader) Read(p []byte) (int, error) {
+	if e.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > e.remaining {
+		p = p[:e.remaining]
+	}
+	n, err := e.r.Read(p)
+	e.hash.Write(p[:n])
+	e.remaining -= int64(n)
+	if e.remaining > 0 {
+		if err == io.EOF {
+			return n, errCompressionChanged
+		}
+		return n, err
+	}
+	var extra [1]byte
+	if m, _ := io.ReadFull(e.r, extra[:]); m > 0 || !bytes.Equal(e.hash.Sum(nil), e.sum) {
+		return 0, errCompressionChanged
+	}
+	return n, io.EOF
+}
+
+// newDecompressor returns a reader decompressing r according to its magic
+// bytes, or r itself if it is not compressed.
+func newDecompressor(r *bufio.Reader) (io.ReadCloser, string, error) {
+	magic, _ := r.Peek(len(zstdMagic))
+	switch DetectCompression(magic) {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, CompressionGzip, err
+		}
+		return gz, CompressionGzip, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, CompressionZstd, err
+		}
+		return zr.IOReadCloser(), CompressionZstd, nil
+	}
+	return io.NopCloser(r), "", nil
+}
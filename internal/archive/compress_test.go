@@ -0,0 +1,132 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// benchmarkPayload returns size bytes that compress roughly like a git
+// archive: runs of text mixed with incompressible pack data.
+func benchmarkPayload(size int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		if rng.Intn(2) == 0 {
+			fmt.Fprintf(&buf, "refs/heads/feature-%d %040x\n", rng.Intn(1000), rng.Int63())
+			continue
+		}
+		chunk := make([]byte, 512)
+		rng.Read(chunk)
+		buf.Write(chunk)
+	}
+	return buf.Bytes()[:size]
+}
+
+// BenchmarkCompress measures compression throughput and reports the bytes
+// sent over the wire per input byte.
+//
+//	go test -run '^$' -bench Compress -benchmem ./internal/archive
+func BenchmarkCompress(b *testing.B) {
+	payload := benchmarkPayload(32 << 20)
+	for _, algorithm := range []string{CompressionGzip, CompressionZstd} {
+		b.Run(algorithm, func(b *testing.B) {
+			b.SetBytes(int64(len(payload)))
+			b.ReportAllocs()
+			var wire int64
+			for i := 0; i < b.N; i++ {
+				stream, err := Compress(bytes.NewReader(payload), algorithm)
+				if err != nil {
+					b.Fatal(err)
+				}
+				n, err := io.Copy(io.Discard, stream)
+				if err != nil {
+					b.Fatal(err)
+				}
+				stream.Close()
+				wire = n
+			}
+			b.ReportMetric(float64(wire), "wire-bytes/op")
+			b.ReportMetric(float64(wire)/float64(len(payload)), "ratio")
+		})
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	payload := benchmarkPayload(1 << 20)
+	for _, algorithm := range []string{CompressionGzip, CompressionZstd} {
+		stream, err := Compress(bytes.NewReader(payload), algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed, err := io.ReadAll(stream)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if got := DetectCompression(compressed); got != algorithm {
+			t.Errorf("%s: detected %q", algorithm, got)
+		}
+		r, _, err := newDecompressor(bufio.NewReader(bytes.NewReader(compressed)))
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("%s: round trip changed the data", algorithm)
+		}
+	}
+}
+
+func TestWithCompressedExt(t *testing.T) {
+	tests := []struct {
+		name, algorithm, want string
+	}{
+		{"repo.tar", CompressionGzip, "repo.tar.gz"},
+		{"repo.tar.gz", CompressionGzip, "repo.tar.gz"},
+		{"repo.TGZ", CompressionGzip, "repo.TGZ"},
+		{"repo.tar", CompressionZstd, "repo.tar.zst"},
+		{"repo.tar.zst", CompressionZstd, "repo.tar.zst"},
+		{"repo.tar.gz", CompressionZstd, "repo.tar.gz.zst"},
+	}
+	for _, tt := range tests {
+		if got := WithCompressedExt(tt.name, tt.algorithm); got != tt.want {
+			t.Errorf("WithCompressedExt(%q, %q) = %q, want %q", tt.name, tt.algorithm, got, tt.want)
+		}
+	}
+}
+
+func TestExpectCompressed(t *testing.T) {
+	payload := benchmarkPayload(1 << 20)
+	size, sum, err := MeasureCompressed(bytes.NewReader(payload), CompressionZstd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := Compress(bytes.NewReader(payload), CompressionZstd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(io.Discard, Expect(stream, size, sum))
+	if err != nil {
+		t.Fatalf("same archive: %v", err)
+	}
+	if n != size {
+		t.Errorf("read %d bytes, measured %d", n, size)
+	}
+
+	changed := append([]byte(nil), payload...)
+	changed[len(changed)/2] ^= 0xff
+	stream, err = Compress(bytes.NewReader(changed), CompressionZstd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, Expect(stream, size, sum)); err == nil {
+		t.Error("changed archive was read without an error")
+	}
+}
@@ -0,0 +1,106 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// archiveExts are the extensions kept together when a name is split, longest
+// first.
+var archiveExts = []string{".tar.gz", ".tar.zst", ".tgz", ".tar", ".gz", ".zst"}
+
+// SplitName splits an archive name into its stem and extension, keeping
+// compound extensions such as .tar.gz whole.
+func SplitName(name string) (stem string, ext string) {
+	lower := strings.ToLower(name)
+	for _, ext := range archiveExts {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)], name[len(name)-len(ext):]
+		}
+	}
+	return name, ""
+}
+
+// NameData is the data available to blob name templates.
+type NameData struct {
+	Org  string // organization the archive is uploaded to
+	Repo string // repository name, or the file's stem when not known
+	File string // name the archive would have without a template
+	Stem string // File without its archive extension
+	Date string // upload date in UTC, 2006-01-02
+	Time string // upload time in UTC, 150405
+}
+
+// NewNameData returns the template data for uploading file to org at now.
+// An empty repo defaults to the stem of file.
+func NewNameData(org, file, repo string, now time.Time) NameData {
+	stem, _ := SplitName(file)
+	if repo == "" {
+		repo = stem
+	}
+	now = now.UTC()
+	return NameData{
+		Org:  org,
+		Repo: strings.TrimSuffix(repo, ".git"),
+		File: file,
+		Stem: stem,
+		Date: now.Format("2006-01-02"),
+		Time: now.Format("150405"),
+	}
+}
+
+// IsNameTemplate reports whether name contains template actions.
+func IsNameTemplate(name string) bool {
+	return strings.Contains(name, "{{")
+}
+
+// NameTemplate renders blob names such as "{{.Repo}}-{{.Date}}-git.tar.gz".
+type NameTemplate struct {
+	tmpl *template.Template
+}
+
+// ParseNameTemplate parses a blob name template. Unknown fields are an error.
+func ParseNameTemplate(s string) (*NameTemplate, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid name template %q: %w", s, err)
+	}
+	// Render once so unknown fields are reported before anything is uploaded
+	if err := tmpl.Execute(io.Discard, NameData{}); err != nil {
+		return nil, fmt.Errorf("invalid name template %q: %w", s, err)
+	}
+	return &NameTemplate{tmpl: tmpl}, nil
+}
+
+// Render returns the name for data.
+func (t *NameTemplate) Render(data NameData) (string, error) {
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render name template: %w", err)
+	}
+	name := strings.TrimSpace(b.String())
+	if err := ValidateName(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// ValidateName checks name can be used as a blob name.
+func ValidateName(name string) error {
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("invalid blob name %q", name)
+	}
+	if strings.ContainsAny(name, "/\\") || filepath.Base(name) != name {
+		return fmt.Errorf("invalid blob name %q: must not contain path separators", name)
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("invalid blob name %q: must not contain control characters", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GitPack is a git archive built from a local repository: a bare mirror with
+// the repository's branches and tags in packed-refs and every reachable
+// object in a single pack. Nothing is written to disk. The repository is
+// packed twice: once to measure the pack and build its index in memory, so
+// the exact Size of the tar is known up front, and again by WriteTo, which
+// streams the pack into the tar and checks that git wrote the same pack.
+type GitPack struct {
+	Name     string
+	Refs     int
+	entries  []packEntry
+	size     int64
+	repoPath string
+	refs     []gitRef
+	checksum [sha1.Size]byte
+}
+
+type packEntry struct {
+	header *tar.Header
+	data   []byte // contents of generated files and the index
+	pack   bool   // the pack, streamed from git pack-objects
+}
+
+// NewGitPack packs the repository at repoPath. name is the repository name
+// used for the top level <name>.git directory and defaults to the name of
+// the repository directory.
+func NewGitPack(repoPath string, name string) (*GitPack, error) {
+	absPath, err := filepath.Abs(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve repository path: %w", err)
+	}
+	if _, err := git(absPath, nil, "rev-parse", "--git-dir"); err != nil {
+		return nil, fmt.Errorf("%s is not a git repository: %w", repoPath, err)
+	}
+	if shallow, err := git(absPath, nil, "rev-parse", "--is-shallow-repository"); err == nil && strings.TrimSpace(shallow) == "true" {
+		return nil, fmt.Errorf("%s is a shallow clone, fetch the full history with git fetch --unshallow first", repoPath)
+	}
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(absPath), ".git")
+	}
+
+	refs, err := mirrorRefs(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("%s has no branches or tags to pack", repoPath)
+	}
+	head := headRef(absPath, refs)
+
+	idx, err := indexObjects(absPath, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &GitPack{Name: name, Refs: len(refs), repoPath: absPath, refs: refs, checksum: idx.checksum}
+	if err := p.addEntries(head, refs, idx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Size returns the exact length of the tar written by WriteTo.
+func (p *GitPack) Size() int64 {
+	return p.size
+}
+
+// FileName returns the name of the archive as uploaded.
+func (p *GitPack) FileName() string {
+	return p.Name + ".tar"
+}
+
+// WriteTo writes the archive as an uncompressed tar to w.
+func (p *GitPack) WriteTo(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	tw := tar.NewWriter(counter)
+	for _, entry := range p.entries {
+		if err := tw.WriteHeader(entry.header); err != nil {
+			return counter.n, fmt.Errorf("failed to write %s: %w", entry.header.Name, err)
+		}
+		if err := p.writeBody(tw, entry); err != nil {
+			return counter.n, fmt.Errorf("failed to write %s: %w", entry.header.Name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return counter.n, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return counter.n, nil
+}
+
+// Reader returns a stream of the archive. Closing it stops the writer.
+func (p *GitPack) Reader() io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := p.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+func (p *GitPack) writeBody(w io.Writer, e packEntry) error {
+	if e.header.Typeflag == tar.TypeDir {
+		return nil
+	}
+	if !e.pack {
+		_, err := w.Write(e.data)
+		return err
+	}
+
+	stream, err := startPackObjects(p.repoPath, p.refs)
+	if err != nil {
+		return err
+	}
+	err = copyPack(w, stream, e.header.Size, p.checksum)
+	if waitErr := stream.wait(); err == nil {
+		err = waitErr
+	}
+	return err
+}
+
+// errPackChanged reports that the second pass of git pack-objects did not
+// write the pack that the first pass measured and indexed.
+var errPackChanged = errors.New("git pack-objects wrote a different pack the second time, the repository changed while it was packed")
+
+// copyPack copies a pack of size bytes from r to w. The trailer of a pack is
+// the SHA-1 of the rest of it, so the data is checked against the trailer
+// and the trailer against checksum before the trailer is written.
+func copyPack(w io.Writer, r io.Reader, size int64, checksum [sha1.Size]byte) error {
+	sum := sha1.New()
+	if _, err := io.CopyN(io.MultiWriter(w, sum), r, size-sha1.Size); err != nil {
+		if err == io.EOF {
+			return errPackChanged
+		}
+		return err
+	}
+	var trailer [sha1.Size]byte
+	if _, err := io.ReadFull(r, trailer[:]); err != nil {
+		return errPackChanged
+	}
+	if trailer != checksum || !bytes.Equal(sum.Sum(nil), trailer[:]) {
+		return errPackChanged
+	}
+	if _, err := w.Write(trailer[:]); err != nil {
+		return err
+	}
+	if n, _ := io.Copy(io.Discard, r); n > 0 {
+		return errPackChanged
+	}
+	return nil
+}
+
+func (p *GitPack) addEntries(head string, refs []gitRef, idx *packIndex) error {
+	root := p.Name + ".git/"
+	now := time.Now().Truncate(time.Second)
+
+	dir := func(name string) {
+		p.entries = append(p.entries, packEntry{header: &tar.Header{
+			Typeflag: tar.TypeDir, Name: root + name, Mode: 0o755, ModTime: now,
+		}})
+	}
+	file := func(name string, data []byte) {
+		p.entries = append(p.entries, packEntry{header: &tar.Header{
+			Typeflag: tar.TypeReg, Name: root + name, Mode: 0o644, Size: int64(len(data)), ModTime: now,
+		}, data: data})
+	}
+
+	var packedRefs bytes.Buffer
+	packedRefs.WriteString("# pack-refs with: sorted \n")
+	for _, ref := range refs {
+		fmt.Fprintf(&packedRefs, "%s %s\n", ref.object, ref.name)
+	}
+
+	p.entries = append(p.entries, packEntry{header: &tar.Header{
+		Typeflag: tar.TypeDir, Name: root, Mode: 0o755, ModTime: now,
+	}})
+	file("HEAD", []byte("ref: "+head+"\n"))
+	file("config", []byte("[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = true\n"))
+	file("packed-refs", packedRefs.Bytes())
+	dir("refs/")
+	dir("refs/heads/")
+	dir("refs/tags/")
+	dir("objects/")
+	dir("objects/info/")
+	dir("objects/pack/")
+	base := root + "objects/pack/pack-" + hex.EncodeToString(idx.checksum[:])
+	p.entries = append(p.entries, packEntry{header: &tar.Header{
+		Typeflag: tar.TypeReg, Name: base + ".pack", Mode: 0o444, Size: idx.size, ModTime: now,
+	}, pack: true})
+	index := idx.Bytes()
+	p.entries = append(p.entries, packEntry{header: &tar.Header{
+		Typeflag: tar.TypeReg, Name: base + ".idx", Mode: 0o444, Size: int64(len(index)), ModTime: now,
+	}, data: index})
+
+	// The size of each header, including any PAX records for long names,
+	// is measured with a throwaway writer; bodies are padded to 512 bytes
+	// and the archive ends with two zero blocks.
+	var size int64
+	for _, entry := range p.entries {
+		counter := &countingWriter{w: io.Discard}
+		if err := tar.NewWriter(counter).WriteHeader(entry.header); err != nil {
+			return fmt.Errorf("failed to encode %s: %w", entry.header.Name, err)
+		}
+		size += counter.n
+		if entry.header.Typeflag == tar.TypeReg {
+			size += (entry.header.Size + 511) / 512 * 512
+		}
+	}
+	p.size = size + 1024
+	return nil
+}
+
+type gitRef struct {
+	object string
+	name   string
+}
+
+// mirrorRefs returns the branches and tags to include. Remote tracking
+// branches of origin become branches when there is no local branch of the
+// same name, so a fresh clone packs all of its upstream's branches.
+func mirrorRefs(repoPath string) ([]gitRef, error) {
+	out, err := git(repoPath, nil, "for-each-ref", "--format=%(objectname) %(refname)", "refs/heads", "refs/tags", "refs/remotes/origin")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+
+	byName := map[string]string{}
+	var remote []gitRef
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		object, name, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(name, "refs/remotes/origin/") {
+			remote = append(remote, gitRef{object: object, name: name})
+			continue
+		}
+		byName[name] = object
+	}
+	for _, ref := range remote {
+		branch := strings.TrimPrefix(ref.name, "refs/remotes/origin/")
+		if branch == "HEAD" {
+			continue
+		}
+		if _, ok := byName["refs/heads/"+branch]; !ok {
+			byName["refs/heads/"+branch] = ref.object
+		}
+	}
+
+	refs := make([]gitRef, 0, len(byName))
+	for name, object := range byName {
+		refs = append(refs, gitRef{object: object, name: name})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].name < refs[j].name })
+	return refs, nil
+}
+
+// headRef returns the branch HEAD should point at: the checked out branch,
+// origin's default branch, or the first branch.
+func headRef(repoPath string, refs []gitRef) string {
+	has := func(name string) bool {
+		for _, ref := range refs {
+			if ref.name == name {
+				return true
+			}
+		}
+		return false
+	}
+	if out, err := git(repoPath, nil, "symbolic-ref", "-q", "HEAD"); err == nil && has(strings.TrimSpace(out)) {
+		return strings.TrimSpace(out)
+	}
+	if out, err := git(repoPath, nil, "symbolic-ref", "-q", "refs/remotes/origin/HEAD"); err == nil {
+		name := "refs/heads/" + strings.TrimPrefix(strings.TrimSpace(out), "refs/remotes/origin/")
+		if has(name) {
+			return name
+		}
+	}
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.name, "refs/heads/") {
+			return ref.name
+		}
+	}
+	return "refs/heads/main"
+}
+
+// indexObjects packs every object reachable from refs and returns the index
+// of the pack, which is read as git writes it and not kept.
+func indexObjects(repoPath string, refs []gitRef) (*packIndex, error) {
+	objects, err := startCatFile(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer objects.Close()
+
+	stream, err := startPackObjects(repoPath, refs)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := indexPack(stream, objects)
+	if err != nil {
+		stream.wait()
+		return nil, fmt.Errorf("failed to index pack: %w", err)
+	}
+	if err := stream.wait(); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// packStream is the output of git pack-objects writing a single pack of
+// every object reachable from a set of refs.
+type packStream struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+}
+
+// startPackObjects starts packing the objects reachable from refs. git packs
+// on one thread so that it writes the same pack every time.
+func startPackObjects(repoPath string, refs []gitRef) (*packStream, error) {
+	var revs bytes.Buffer
+	for _, ref := range refs {
+		revs.WriteString(ref.object + "\n")
+	}
+	cmd := exec.Command("git", "-C", repoPath, "pack-objects", "--revs", "--stdout", "--delta-base-offset", "--threads=1", "-q")
+	cmd.Stdin = &revs
+	s := &packStream{cmd: cmd}
+	cmd.Stderr = &s.stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	s.ReadCloser = stdout
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to pack objects: %w", err)
+	}
+	return s, nil
+}
+
+// wait stops reading the pack and waits for git to exit.
+func (s *packStream) wait() error {
+	s.Close()
+	if err := s.cmd.Wait(); err != nil {
+		if msg := strings.TrimSpace(s.stderr.String()); msg != "" {
+			return fmt.Errorf("failed to pack objects: %v: %s", err, msg)
+		}
+		return fmt.Errorf("failed to pack objects: %w", err)
+	}
+	return nil
+}
+
+func git(repoPath string, stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", repoPath}, args...)...)
+	cmd.Stdin = stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			return "", err
+		}
+		return "", fmt.Errorf("%v: %s", err, msg)
+	}
+	return stdout.String(), nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
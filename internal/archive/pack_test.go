@@ -0,0 +1,95 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGitPackIndex packs a repository with delta chains and checks that the
+// index built in memory is the one git index-pack writes for the pack.
+func TestGitPackIndex(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	run := func(dir string, args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+		}
+	}
+	run(repo, "init", "-q", "-b", "main")
+	var lines strings.Builder
+	for i := 0; i < 20; i++ {
+		for j := 0; j < 200; j++ {
+			fmt.Fprintf(&lines, "line %d of commit %d\n", j, i)
+		}
+		if err := os.WriteFile(filepath.Join(repo, "file.txt"), []byte(lines.String()), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		run(repo, "add", "file.txt")
+		run(repo, "commit", "-q", "-m", fmt.Sprintf("commit %d", i))
+	}
+	run(repo, "tag", "-a", "v1", "-m", "release")
+	run(repo, "repack", "-adq")
+
+	pack, err := NewGitPack(repo, "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	n, err := pack.WriteTo(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != pack.Size() {
+		t.Errorf("WriteTo wrote %d bytes, Size is %d", n, pack.Size())
+	}
+
+	out := t.TempDir()
+	files := map[string][]byte{}
+	tr := tar.NewReader(&archive)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ext := filepath.Ext(hdr.Name); ext == ".pack" || ext == ".idx" {
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[ext] = data
+		}
+	}
+	if files[".pack"] == nil || files[".idx"] == nil {
+		t.Fatalf("archive is missing the pack or its index")
+	}
+
+	packFile := filepath.Join(out, "test.pack")
+	if err := os.WriteFile(packFile, files[".pack"], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run(out, "index-pack", "-o", filepath.Join(out, "want.idx"), packFile)
+	want, err := os.ReadFile(filepath.Join(out, "want.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(files[".idx"], want) {
+		t.Error("index differs from the one written by git index-pack")
+	}
+}
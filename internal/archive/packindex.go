@@ -0,0 +1,378 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Object types in a pack
+const (
+	objCommit   = 1
+	objTree     = 2
+	objBlob     = 3
+	objTag      = 4
+	objOfsDelta = 6
+	objRefDelta = 7
+)
+
+var objTypeNames = map[byte]string{
+	objCommit: "commit",
+	objTree:   "tree",
+	objBlob:   "blob",
+	objTag:    "tag",
+}
+
+// packIndex is the version 2 index of a pack, built by reading the pack as
+// it streams past so the pack never has to be on disk for git index-pack.
+type packIndex struct {
+	objects  []packObject
+	size     int64
+	checksum [sha1.Size]byte
+}
+
+type packObject struct {
+	id     [sha1.Size]byte
+	offset int64
+	crc    uint32
+}
+
+// packReader counts, checksums and CRCs the bytes of a pack. It reads byte
+// by byte where needed, so zlib stops exactly at the end of each object.
+type packReader struct {
+	r      *bufio.Reader
+	offset int64
+	sum    hash.Hash
+	crc    hash.Hash32
+}
+
+func (p *packReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.update(b[:n])
+	return n, err
+}
+
+func (p *packReader) ReadByte() (byte, error) {
+	c, err := p.r.ReadByte()
+	if err == nil {
+		p.update([]byte{c})
+	}
+	return c, err
+}
+
+func (p *packReader) update(b []byte) {
+	p.offset += int64(len(b))
+	p.sum.Write(b)
+	p.crc.Write(b)
+}
+
+// indexPack reads a whole pack from r and returns its index. The base of an
+// object stored as a delta is read from the repository through objects.
+func indexPack(r io.Reader, objects *catFile) (*packIndex, error) {
+	p := &packReader{r: bufio.NewReaderSize(r, 64<<10), sum: sha1.New(), crc: crc32.NewIEEE()}
+
+	var header [12]byte
+	if _, err := io.ReadFull(p, header[:]); err != nil {
+		return nil, fmt.Errorf("failed to read pack header: %w", err)
+	}
+	if string(header[:4]) != "PACK" {
+		return nil, errors.New("not a git pack")
+	}
+	if version := binary.BigEndian.Uint32(header[4:8]); version != 2 && version != 3 {
+		return nil, fmt.Errorf("unsupported pack version %d", version)
+	}
+	count := binary.BigEndian.Uint32(header[8:12])
+
+	idx := &packIndex{objects: make([]packObject, 0, count)}
+	for i := uint32(0); i < count; i++ {
+		offset := p.offset
+		p.crc.Reset()
+		id, err := idx.readObject(p, offset, objects)
+		if err != nil {
+			return nil, fmt.Errorf("failed to index object at offset %d: %w", offset, err)
+		}
+		idx.objects = append(idx.objects, packObject{id: id, offset: offset, crc: p.crc.Sum32()})
+	}
+
+	want := p.sum.Sum(nil)
+	if _, err := io.ReadFull(p, idx.checksum[:]); err != nil {
+		return nil, fmt.Errorf("failed to read pack checksum: %w", err)
+	}
+	if !bytes.Equal(idx.checksum[:], want) {
+		return nil, errors.New("pack checksum mismatch")
+	}
+	if _, err := p.ReadByte(); err != io.EOF {
+		return nil, errors.New("unexpected data after the pack")
+	}
+	idx.size = p.offset
+	return idx, nil
+}
+
+// readObject reads the object at offset and returns its ID.
+func (idx *packIndex) readObject(p *packReader, offset int64, objects *catFile) ([sha1.Size]byte, error) {
+	var id [sha1.Size]byte
+	c, err := p.ReadByte()
+	if err != nil {
+		return id, err
+	}
+	typ := c >> 4 & 7
+	size := int64(c & 15)
+	for shift := 4; c&0x80 != 0; shift += 7 {
+		if c, err = p.ReadByte(); err != nil {
+			return id, err
+		}
+		size |= int64(c&0x7f) << shift
+	}
+
+	var base [sha1.Size]byte
+	switch typ {
+	case objCommit, objTree, objBlob, objTag:
+		h := sha1.New()
+		fmt.Fprintf(h, "%s %d\x00", objTypeNames[typ], size)
+		if err := inflate(p, h, size); err != nil {
+			return id, err
+		}
+		copy(id[:], h.Sum(nil))
+		return id, nil
+	case objOfsDelta:
+		c, err := p.ReadByte()
+		if err != nil {
+			return id, err
+		}
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = p.ReadByte(); err != nil {
+				return id, err
+			}
+			rel = (rel+1)<<7 | int64(c&0x7f)
+		}
+		if base, err = idx.idAt(offset - rel); err != nil {
+			return id, err
+		}
+	case objRefDelta:
+		if _, err := io.ReadFull(p, base[:]); err != nil {
+			return id, err
+		}
+	default:
+		return id, fmt.Errorf("unknown object type %d", typ)
+	}
+
+	var delta bytes.Buffer
+	if err := inflate(p, &delta, size); err != nil {
+		return id, err
+	}
+	baseType, baseData, err := objects.object(base)
+	if err != nil {
+		return id, err
+	}
+	return applyDelta(baseType, baseData, delta.Bytes())
+}
+
+// idAt returns the ID of the object at offset, which must already have been
+// read.
+func (idx *packIndex) idAt(offset int64) ([sha1.Size]byte, error) {
+	i := sort.Search(len(idx.objects), func(i int) bool { return idx.objects[i].offset >= offset })
+	if i == len(idx.objects) || idx.objects[i].offset != offset {
+		return [sha1.Size]byte{}, fmt.Errorf("no object at delta base offset %d", offset)
+	}
+	return idx.objects[i].id, nil
+}
+
+// inflate decompresses one object of size bytes from p into w.
+func inflate(p *packReader, w io.Writer, size int64) error {
+	zr, err := zlib.NewReader(p)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	n, err := io.Copy(w, zr)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("object is %d bytes, header says %d", n, size)
+	}
+	return nil
+}
+
+// applyDelta returns the ID of the object that delta builds from base.
+func applyDelta(typ string, base, delta []byte) ([sha1.Size]byte, error) {
+	var id [sha1.Size]byte
+	varint := func() (int64, error) {
+		var v int64
+		for shift := 0; ; shift += 7 {
+			if len(delta) == 0 {
+				return 0, errors.New("truncated delta")
+			}
+			c := delta[0]
+			delta = delta[1:]
+			v |= int64(c&0x7f) << shift
+			if c&0x80 == 0 {
+				return v, nil
+			}
+		}
+	}
+	baseSize, err := varint()
+	if err != nil {
+		return id, err
+	}
+	if baseSize != int64(len(base)) {
+		return id, fmt.Errorf("delta base is %d bytes, delta expects %d", len(base), baseSize)
+	}
+	size, err := varint()
+	if err != nil {
+		return id, err
+	}
+
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", typ, size)
+	var written int64
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+		switch {
+		case op&0x80 != 0:
+			var offset, n int64
+			for i := 0; i < 7; i++ {
+				if op&(1<<i) == 0 {
+					continue
+				}
+				if len(delta) == 0 {
+					return id, errors.New("truncated delta")
+				}
+				if i < 4 {
+					offset |= int64(delta[0]) << (8 * i)
+				} else {
+					n |= int64(delta[0]) << (8 * (i - 4))
+				}
+				delta = delta[1:]
+			}
+			if n == 0 {
+				n = 0x10000
+			}
+			if offset+n > int64(len(base)) {
+				return id, errors.New("delta copies past the end of its base")
+			}
+			h.Write(base[offset : offset+n])
+			written += n
+		case op != 0:
+			if int(op) > len(delta) {
+				return id, errors.New("truncated delta")
+			}
+			h.Write(delta[:op])
+			delta = delta[op:]
+			written += int64(op)
+		default:
+			return id, errors.New("invalid delta instruction")
+		}
+	}
+	if written != size {
+		return id, fmt.Errorf("delta built %d bytes, expected %d", written, size)
+	}
+	copy(id[:], h.Sum(nil))
+	return id, nil
+}
+
+// Bytes encodes the index in the version 2 format of git index-pack.
+func (idx *packIndex) Bytes() []byte {
+	objects := append([]packObject(nil), idx.objects...)
+	sort.Slice(objects, func(i, j int) bool { return bytes.Compare(objects[i].id[:], objects[j].id[:]) < 0 })
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 't', 'O', 'c', 0, 0, 0, 2})
+	var fanout [256]uint32
+	for _, o := range objects {
+		fanout[o.id[0]]++
+	}
+	var total uint32
+	for i := range fanout {
+		total += fanout[i]
+		binary.Write(&buf, binary.BigEndian, total)
+	}
+	for _, o := range objects {
+		buf.Write(o.id[:])
+	}
+	for _, o := range objects {
+		binary.Write(&buf, binary.BigEndian, o.crc)
+	}
+	// Offsets past 2 GiB go in a table of 64 bit offsets
+	var large []int64
+	for _, o := range objects {
+		if o.offset < 1<<31 {
+			binary.Write(&buf, binary.BigEndian, uint32(o.offset))
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(len(large))|1<<31)
+		large = append(large, o.offset)
+	}
+	for _, offset := range large {
+		binary.Write(&buf, binary.BigEndian, uint64(offset))
+	}
+	buf.Write(idx.checksum[:])
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
+
+// catFile reads objects from a repository through git cat-file --batch.
+type catFile struct {
+	cmd *exec.Cmd
+	in  io.WriteCloser
+	out *bufio.Reader
+}
+
+func startCatFile(repoPath string) (*catFile, error) {
+	cmd := exec.Command("git", "-C", repoPath, "cat-file", "--batch")
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start git cat-file: %w", err)
+	}
+	return &catFile{cmd: cmd, in: in, out: bufio.NewReader(out)}, nil
+}
+
+// object returns the type and contents of the object id.
+func (c *catFile) object(id [sha1.Size]byte) (string, []byte, error) {
+	if _, err := fmt.Fprintf(c.in, "%x\n", id); err != nil {
+		return "", nil, fmt.Errorf("failed to read object %x: %w", id, err)
+	}
+	line, err := c.out.ReadString('\n')
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read object %x: %w", id, err)
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return "", nil, fmt.Errorf("object %x is not in the repository", id)
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read object %x: %w", id, err)
+	}
+	data := make([]byte, size+1)
+	if _, err := io.ReadFull(c.out, data); err != nil {
+		return "", nil, fmt.Errorf("failed to read object %x: %w", id, err)
+	}
+	return fields[1], data[:size], nil
+}
+
+func (c *catFile) Close() error {
+	c.in.Close()
+	return c.cmd.Wait()
+}
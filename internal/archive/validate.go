@@ -0,0 +1,216 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/robandpdx/gh-blob/pkg/units"
+)
+
+const (
+	TypeGit      = "git"
+	TypeMetadata = "metadata"
+
+	// MaxArchiveSize is the largest archive GitHub-owned storage accepts.
+	MaxArchiveSize int64 = 40 * units.GiB
+)
+
+// Report describes what Validate found in an archive. Problems make the
+// archive unusable for a migration; warnings are worth a look but do not.
+type Report struct {
+	Path       string   `json:"path"`
+	Type       string   `json:"type,omitempty"`
+	Compressed bool     `json:"compressed"`
+	Size       int64    `json:"size"`
+	Entries    int      `json:"entries"`
+	Problems   []string `json:"problems,omitempty"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+// Valid reports whether no problems were found.
+func (r *Report) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *Report) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+func (r *Report) warn(format string, args ...interface{}) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// layout collects the entries of an archive that identify its kind.
+type layout struct {
+	bareRepos    map[string]bool // directories containing HEAD, objects/ and refs/
+	heads        map[string]bool
+	objects      map[string]bool
+	refs         map[string]bool
+	hasSchema    bool
+	hasUrls      bool
+	hasRepoFiles bool
+}
+
+func (l *layout) add(name string) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	base := path.Base(name)
+
+	if base == "HEAD" {
+		l.heads[path.Dir(name)] = true
+	}
+	// Record the directory above any objects/ or refs/ component as a
+	// candidate repository root
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		root := "."
+		if i > 0 {
+			root = strings.Join(parts[:i], "/")
+		}
+		switch part {
+		case "objects":
+			l.objects[root] = true
+		case "refs":
+			l.refs[root] = true
+		}
+	}
+
+	if strings.HasSuffix(base, ".json") {
+		switch {
+		case base == "schema.json":
+			l.hasSchema = true
+		case base == "urls.json":
+			l.hasUrls = true
+		case strings.HasPrefix(base, "repositories_"):
+			l.hasRepoFiles = true
+		}
+	}
+}
+
+func (l *layout) findBareRepos() {
+	for dir := range l.heads {
+		if l.objects[dir] && l.refs[dir] {
+			l.bareRepos[dir] = true
+		}
+	}
+}
+
+func (l *layout) looksLikeGit() bool {
+	return len(l.bareRepos) > 0
+}
+
+func (l *layout) looksLikeMetadata() bool {
+	return l.hasSchema || l.hasUrls || l.hasRepoFiles
+}
+
+// Validate checks that the file at path is a readable tar, tar.gz or tar.zst
+// archive with the layout of a GEI git archive (a bare repository) or
+// metadata archive (schema.json, urls.json and repositories_*.json). An empty
+// expectedType detects the kind. The returned error is only set when the
+// file cannot be read at all.
+func Validate(filePath string, expectedType string) (*Report, error) {
+	if expectedType != "" && expectedType != TypeGit && expectedType != TypeMetadata {
+		return nil, fmt.Errorf("invalid archive type %q: must be git or metadata", expectedType)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat archive: %w", err)
+	}
+	report := &Report{Path: filePath, Size: info.Size()}
+
+	if report.Size == 0 {
+		report.problem("archive is empty")
+		return report, nil
+	}
+	if report.Size > MaxArchiveSize {
+		report.problem("archive is %s, larger than the %s limit", units.FormatBytes(report.Size), units.FormatBytes(MaxArchiveSize))
+	}
+
+	reader, compression, err := newDecompressor(bufio.NewReader(f))
+	report.Compressed = compression != ""
+	if err != nil {
+		report.problem("archive is not a readable %s file: %v", compression, err)
+		return report, nil
+	}
+	defer reader.Close()
+
+	l := &layout{
+		bareRepos: map[string]bool{},
+		heads:     map[string]bool{},
+		objects:   map[string]bool{},
+		refs:      map[string]bool{},
+	}
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			report.problem("archive is not a readable tar file after %d entries: %v", report.Entries, err)
+			return report, nil
+		}
+		report.Entries++
+		l.add(header.Name)
+
+		// Read through the entry so truncated or corrupt data is detected
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			report.problem("entry %s is corrupt: %v", header.Name, err)
+			return report, nil
+		}
+	}
+	// Drain the padding after the end of the tar so a checksum
+	// mismatch or missing trailer is detected
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		report.problem("archive is truncated or corrupt: %v", err)
+		return report, nil
+	}
+	if report.Entries == 0 {
+		report.problem("archive contains no files")
+		return report, nil
+	}
+	l.findBareRepos()
+
+	switch {
+	case l.looksLikeGit() && !l.looksLikeMetadata():
+		report.Type = TypeGit
+	case l.looksLikeMetadata() && !l.looksLikeGit():
+		report.Type = TypeMetadata
+	case l.looksLikeGit() && l.looksLikeMetadata():
+		report.warn("archive contains both a git repository and migration metadata")
+		report.Type = expectedType
+	}
+
+	switch expectedType {
+	case "":
+		if !l.looksLikeGit() && !l.looksLikeMetadata() {
+			report.problem("archive contains neither a bare git repository nor migration metadata JSON files")
+		}
+	case TypeGit:
+		if !l.looksLikeGit() {
+			report.problem("git archive contains no bare git repository (HEAD, objects/ and refs/)")
+		}
+	case TypeMetadata:
+		if !l.looksLikeMetadata() {
+			report.problem("metadata archive contains no schema.json, urls.json or repositories_*.json")
+		} else if !l.hasSchema {
+			report.warn("metadata archive contains no schema.json")
+		}
+	}
+	if report.Type == TypeGit && len(l.bareRepos) > 1 {
+		report.warn("git archive contains %d repositories, expected one", len(l.bareRepos))
+	}
+	return report, nil
+}
@@ -0,0 +1,207 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/config"
+	"github.com/robandpdx/gh-blob/internal/transport"
+)
+
+const (
+	OperationUpload     = "upload"
+	OperationDelete     = "delete"
+	OperationSoftDelete = "soft-delete"
+	OperationRestore    = "restore"
+
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Record describes a single blob mutation.
+type Record struct {
+	Timestamp time.Time `json:"timestamp"`
+	Actor     string    `json:"actor,omitempty"`
+	Host      string    `json:"host,omitempty"`
+	Org       string    `json:"org,omitempty"`
+	Operation string    `json:"operation"`
+	BlobID    string    `json:"blob_id,omitempty"`
+	GUID      string    `json:"guid,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Size      int64     `json:"size,omitempty"`
+	Checksum  string    `json:"checksum,omitempty"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Sink receives audit records.
+type Sink interface {
+	Write(record Record) error
+}
+
+// FileSink appends records as JSON lines to a local file.
+type FileSink struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// WebhookSink POSTs each record as JSON to an HTTP endpoint.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// DefaultPath returns audit.jsonl in the gh-blob state directory.
+func DefaultPath() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "audit.jsonl"), nil
+}
+
+// NewSink returns a WebhookSink for http(s) URLs and a FileSink for anything
+// else. An empty target selects the default audit file.
+func NewSink(target string) (Sink, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return &WebhookSink{URL: target, Client: &http.Client{Transport: transport.RoundTripper(), Timeout: 30 * time.Second}}, nil
+	}
+	if target == "" {
+		path, err := DefaultPath()
+		if err != nil {
+			return nil, err
+		}
+		target = path
+	}
+	return &FileSink{Path: target}, nil
+}
+
+// Write appends record as one line. The line is written with a single call
+// on a file opened for appending, so records from other processes are not
+// interleaved with it.
+func (s *FileSink) Write(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to create audit directory: %v", err)
+	}
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit record: %v", err)
+	}
+	return nil
+}
+
+func (s *WebhookSink) Write(record Record) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %v", err)
+	}
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create audit request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gh-blob")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send audit record: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected audit sink response status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Filter selects records in Read. Zero values match everything.
+type Filter struct {
+	Org       string
+	Operation string
+	Actor     string
+	Outcome   string
+	Blob      string // matches blob ID, GUID or name
+	Since     time.Time
+	Until     time.Time
+}
+
+func (f Filter) Match(r Record) bool {
+	if f.Org != "" && !strings.EqualFold(f.Org, r.Org) {
+		return false
+	}
+	if f.Operation != "" && f.Operation != r.Operation {
+		return false
+	}
+	if f.Actor != "" && !strings.EqualFold(f.Actor, r.Actor) {
+		return false
+	}
+	if f.Outcome != "" && f.Outcome != r.Outcome {
+		return false
+	}
+	if f.Blob != "" && f.Blob != r.BlobID && f.Blob != r.GUID && f.Blob != r.Name {
+		return false
+	}
+	if !f.Since.IsZero() && r.Timestamp.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && r.Timestamp.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Read returns the records in the audit file at path that match filter, in
+// the order they were written. A missing file yields no records.
+func Read(path string, filter Filter) ([]Record, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %v", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("failed to parse audit file line %d: %v", line, err)
+		}
+		if filter.Match(record) {
+			records = append(records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit file: %v", err)
+	}
+	return records, nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/google/go-github/v69/github"
 	gitlab "gitlab.com/gitlab-org/api/client-go"
 
+	"github.com/robandpdx/gh-blob/internal/transport"
 	"github.com/robandpdx/gh-blob/pkg/logger"
 )
 
@@ -67,7 +68,7 @@ func (g *GitHubClientImpl) GitHubAuth() (*github.Client, error) {
 		logger.Logger.Error("GitHub PAT is not set")
 		return nil, fmt.Errorf("GITHUB_TOKEN environment variable is not set")
 	}
-	client := github.NewClient(nil).WithAuthToken(g.githubPAT)
+	client := github.NewClient(transport.Client()).WithAuthToken(g.githubPAT)
 	if client == nil {
 		logger.Logger.Error("Failed to create GitHub client")
 		return nil, fmt.Errorf("failed to initialize GitHub client")
@@ -0,0 +1,300 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/robandpdx/gh-blob/internal/throttle"
+	"github.com/robandpdx/gh-blob/pkg/units"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultProfileName = "default"
+	LocalConfigName    = ".gh-blob.yml"
+	ProfileEnvVar      = "GH_BLOB_PROFILE"
+)
+
+// Keys lists the settings a profile can hold. Each key matches the name of
+// the command line flag it provides a default for.
+var Keys = []string{
+	"org",
+	"part-size",
+	"concurrency",
+	"output",
+	"log-level",
+	"audit-log",
+	"limit-rate",
+	"limit-rate-schedule",
+	"ca-bundle",
+	"client-cert",
+	"client-key",
+}
+
+type Profile struct {
+	Org         string `yaml:"org,omitempty"`
+	PartSize    string `yaml:"part-size,omitempty"`
+	Concurrency string `yaml:"concurrency,omitempty"`
+	Output      string `yaml:"output,omitempty"`
+	LogLevel    string `yaml:"log-level,omitempty"`
+	AuditLog    string `yaml:"audit-log,omitempty"`
+	LimitRate   string `yaml:"limit-rate,omitempty"`
+	Schedule    string `yaml:"limit-rate-schedule,omitempty"`
+	CABundle    string `yaml:"ca-bundle,omitempty"`
+	ClientCert  string `yaml:"client-cert,omitempty"`
+	ClientKey   string `yaml:"client-key,omitempty"`
+}
+
+type Config struct {
+	DefaultProfile string              `yaml:"default-profile,omitempty"`
+	Profiles       map[string]*Profile `yaml:"profiles,omitempty"`
+}
+
+// GlobalPath returns the location of the user wide config file,
+// $XDG_CONFIG_HOME/gh-blob/config.yml or ~/.config/gh-blob/config.yml.
+func GlobalPath() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "gh-blob", "config.yml"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %v", err)
+	}
+	return filepath.Join(home, ".config", "gh-blob", "config.yml"), nil
+}
+
+// StateDir returns the directory gh-blob keeps its local state in,
+// $XDG_STATE_HOME/gh-blob or ~/.local/state/gh-blob.
+func StateDir() (string, error) {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "gh-blob"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine home directory: %v", err)
+	}
+	return filepath.Join(home, ".local", "state", "gh-blob"), nil
+}
+
+// LocalPath walks up from the working directory looking for a repo-local
+// .gh-blob.yml, stopping at the repository root. It returns an empty string
+// when there is none.
+func LocalPath() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		candidate := filepath.Join(dir, LocalConfigName)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// ReadFile loads a single config file. A missing file yields an empty config.
+func ReadFile(path string) (*Config, error) {
+	cfg := &Config{Profiles: map[string]*Profile{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %v", path, err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]*Profile{}
+	}
+	for name, profile := range cfg.Profiles {
+		if profile == nil {
+			cfg.Profiles[name] = &Profile{}
+		}
+	}
+	return cfg, nil
+}
+
+// WriteFile saves the config to path, creating parent directories as needed.
+func WriteFile(path string, cfg *Config) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Load reads the global config and overlays the repo-local one on top of it.
+func Load() (*Config, error) {
+	globalPath, err := GlobalPath()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ReadFile(globalPath)
+	if err != nil {
+		return nil, err
+	}
+
+	localPath := LocalPath()
+	if localPath == "" {
+		return cfg, nil
+	}
+	local, err := ReadFile(localPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg.merge(local)
+	return cfg, nil
+}
+
+func (c *Config) merge(other *Config) {
+	if other.DefaultProfile != "" {
+		c.DefaultProfile = other.DefaultProfile
+	}
+	for name, profile := range other.Profiles {
+		existing, ok := c.Profiles[name]
+		if !ok {
+			existing = &Profile{}
+			c.Profiles[name] = existing
+		}
+		for _, key := range Keys {
+			if value := profile.Get(key); value != "" {
+				_ = existing.Set(key, value)
+			}
+		}
+	}
+}
+
+// ProfileName resolves which profile to use: the explicit name if given,
+// then $GH_BLOB_PROFILE, then the configured default, then "default".
+func (c *Config) ProfileName(explicit string) string {
+	if explicit != "" {
+		return explicit
+	}
+	if env := os.Getenv(ProfileEnvVar); env != "" {
+		return env
+	}
+	if c.DefaultProfile != "" {
+		return c.DefaultProfile
+	}
+	return DefaultProfileName
+}
+
+// Profile returns the named profile. Asking for an unknown profile is an
+// error unless it is the implicit default.
+func (c *Config) Profile(name string) (*Profile, error) {
+	if profile, ok := c.Profiles[name]; ok {
+		return profile, nil
+	}
+	if name == DefaultProfileName {
+		return &Profile{}, nil
+	}
+	return nil, fmt.Errorf("profile %q not found", name)
+}
+
+// ProfileNames returns the configured profile names in sorted order.
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Profile) field(key string) (*string, error) {
+	switch key {
+	case "org":
+		return &p.Org, nil
+	case "part-size":
+		return &p.PartSize, nil
+	case "concurrency":
+		return &p.Concurrency, nil
+	case "output":
+		return &p.Output, nil
+	case "log-level":
+		return &p.LogLevel, nil
+	case "audit-log":
+		return &p.AuditLog, nil
+	case "limit-rate":
+		return &p.LimitRate, nil
+	case "limit-rate-schedule":
+		return &p.Schedule, nil
+	case "ca-bundle":
+		return &p.CABundle, nil
+	case "client-cert":
+		return &p.ClientCert, nil
+	case "client-key":
+		return &p.ClientKey, nil
+	}
+	return nil, fmt.Errorf("unknown config key %q", key)
+}
+
+// Get returns the value stored for key, or an empty string when unset or
+// unknown.
+func (p *Profile) Get(key string) string {
+	field, err := p.field(key)
+	if err != nil {
+		return ""
+	}
+	return *field
+}
+
+// Set stores value under key. An empty value unsets the key.
+func (p *Profile) Set(key, value string) error {
+	field, err := p.field(key)
+	if err != nil {
+		return err
+	}
+	if err := validate(key, value); err != nil {
+		return err
+	}
+	*field = value
+	return nil
+}
+
+func validate(key, value string) error {
+	if value == "" {
+		return nil
+	}
+	switch key {
+	case "part-size":
+		if _, err := units.ParseBytes(value); err != nil {
+			return fmt.Errorf("invalid part-size: %v", err)
+		}
+	case "limit-rate":
+		if _, err := units.ParseRate(value); err != nil {
+			return fmt.Errorf("invalid limit-rate: %v", err)
+		}
+	case "limit-rate-schedule":
+		if _, err := throttle.ParseSchedule(value); err != nil {
+			return fmt.Errorf("invalid limit-rate-schedule: %v", err)
+		}
+	case "concurrency":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid concurrency %q: must be a positive integer", value)
+		}
+	}
+	return nil
+}
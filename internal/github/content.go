@@ -0,0 +1,142 @@
+package github
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"github.com/cli/go-gh/v2/pkg/api"
+	"github.com/robandpdx/gh-blob/internal/transport"
+)
+
+func newRESTClient() (*api.RESTClient, error) {
+	opts := api.ClientOptions{
+		Headers:   map[string]string{"Accept": "application/vnd.github+json"},
+		Transport: transport.RoundTripper(),
+	}
+	client, err := api.NewRESTClient(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GitHub client: %v", err)
+	}
+	return client, nil
+}
+
+func isNotFound(err error) bool {
+	var httpErr *api.HTTPError
+	return errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound
+}
+
+// GetGistFile returns the content of a file in a gist and whether the file
+// exists.
+func GetGistFile(gistID string, fileName string) (string, bool, error) {
+	client, err := newRESTClient()
+	if err != nil {
+		return "", false, err
+	}
+	var gist struct {
+		Files map[string]struct {
+			Content   string `json:"content"`
+			Truncated bool   `json:"truncated"`
+			RawURL    string `json:"raw_url"`
+		} `json:"files"`
+	}
+	if err := client.Get("gists/"+neturl.PathEscape(gistID), &gist); err != nil {
+		return "", false, fmt.Errorf("failed to get gist %s: %w", gistID, err)
+	}
+	file, ok := gist.Files[fileName]
+	if !ok {
+		return "", false, nil
+	}
+	if file.Truncated {
+		return "", false, fmt.Errorf("%s in gist %s is too large to sync", fileName, gistID)
+	}
+	return file.Content, true, nil
+}
+
+// UpdateGistFile creates or replaces a file in a gist.
+func UpdateGistFile(gistID string, fileName string, content string) error {
+	client, err := newRESTClient()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"files": map[string]interface{}{
+			fileName: map[string]string{"content": content},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode gist update: %v", err)
+	}
+	if err := client.Patch("gists/"+neturl.PathEscape(gistID), bytes.NewReader(body), nil); err != nil {
+		return fmt.Errorf("failed to update gist %s: %w", gistID, err)
+	}
+	return nil
+}
+
+// GetRepoFile returns the content of a file on the default branch of a
+// repository given as owner/name, the blob SHA needed to update it, and
+// whether the file exists.
+func GetRepoFile(repo string, path string) (string, string, bool, error) {
+	client, err := newRESTClient()
+	if err != nil {
+		return "", "", false, err
+	}
+	var file struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+		SHA      string `json:"sha"`
+	}
+	err = client.Get(repoContentsPath(repo, path), &file)
+	if isNotFound(err) {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to get %s from %s: %w", path, repo, err)
+	}
+	if file.Encoding != "base64" {
+		return "", "", false, fmt.Errorf("%s in %s is too large to sync", path, repo)
+	}
+	content, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(file.Content, "\n", ""))
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to decode %s from %s: %v", path, repo, err)
+	}
+	return string(content), file.SHA, true, nil
+}
+
+// PutRepoFile commits content to a file on the default branch of a
+// repository. sha is the blob SHA of the file being replaced, or empty to
+// create it.
+func PutRepoFile(repo string, path string, content string, sha string, message string) error {
+	client, err := newRESTClient()
+	if err != nil {
+		return err
+	}
+	request := map[string]string{
+		"message": message,
+		"content": base64.StdEncoding.EncodeToString([]byte(content)),
+	}
+	if sha != "" {
+		request["sha"] = sha
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode file update: %v", err)
+	}
+	if err := client.Put(repoContentsPath(repo, path), bytes.NewReader(body), nil); err != nil {
+		return fmt.Errorf("failed to update %s in %s: %w", path, repo, err)
+	}
+	return nil
+}
+
+func repoContentsPath(repo string, path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = neturl.PathEscape(segment)
+	}
+	return fmt.Sprintf("repos/%s/contents/%s", repo, strings.Join(segments, "/"))
+}
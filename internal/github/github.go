@@ -4,15 +4,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/cli/go-gh/v2/pkg/api"
 	"github.com/robandpdx/gh-blob/internal/clients"
+	"github.com/robandpdx/gh-blob/internal/throttle"
+	"github.com/robandpdx/gh-blob/internal/transport"
 	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
 
 	"github.com/shurcooL/graphql"
@@ -25,9 +30,14 @@ const (
 	DefaultMultipartThreshold int64 = 5000 * 1024 * 1024 // 5 GB
 )
 
+// uploadsURL is where archives are uploaded; tests point it at a local
+// server.
+var uploadsURL = "https://uploads.github.com"
+
 func GetOrgInfo(orgName string) (*OrgQuery, error) {
 	opts := api.ClientOptions{
-		Headers: map[string]string{"Accept": "application/json"},
+		Headers:   map[string]string{"Accept": "application/json"},
+		Transport: transport.RoundTripper(),
 	}
 
 	client, err := api.NewGraphQLClient(opts)
@@ -52,12 +62,45 @@ func GetOrgInfo(orgName string) (*OrgQuery, error) {
 	return &query, nil
 }
 
+// GetViewerLogin returns the login of the user the token belongs to.
+func GetViewerLogin() (string, error) {
+	opts := api.ClientOptions{
+		Headers:   map[string]string{"Accept": "application/json"},
+		Transport: transport.RoundTripper(),
+	}
+
+	client, err := api.NewGraphQLClient(opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to create GitHub client: %v", err)
+	}
+
+	var query ViewerQuery
+	err = client.Query("GetViewer", &query, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to query GitHub API: %v", err)
+	}
+
+	return query.Viewer.Login, nil
+}
+
+// ErrBlobNotFound is returned when deleting a migration archive that does not
+// exist, e.g. because it was already deleted.
+var ErrBlobNotFound = errors.New("migration archive not found")
+
+// IsNodeNotFound reports whether err is GitHub saying the node queried by ID
+// does not exist, as opposed to the query failing.
+func IsNodeNotFound(err error) bool {
+	var gqlErr *api.GraphQLError
+	return errors.As(err, &gqlErr) && gqlErr.Match("NOT_FOUND", "node")
+}
+
 func QueryBlobFromGitHub(blobId string) (*BlobQuery, error) {
 	opts := api.ClientOptions{
 		Headers: map[string]string{
 			"Accept":           "application/json",
 			"GraphQL-Features": "octoshift_github_owned_storage",
 		},
+		Transport: transport.RoundTripper(),
 	}
 
 	client, err := api.NewGraphQLClient(opts)
@@ -76,7 +119,7 @@ func QueryBlobFromGitHub(blobId string) (*BlobQuery, error) {
 	}
 	err = client.Query("QueryBlob", &query, variables)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query GitHub API: %v", err)
+		return nil, fmt.Errorf("failed to query GitHub API: %w", err)
 	}
 
 	ghlog.Logger.Info("Blob ID: " + query.Node.MigrationArchive.ID)
@@ -95,6 +138,7 @@ func QueryAllBlobsFromGitHub(orgName string) (*AllBlobsQuery, error) {
 			"Accept":           "application/json",
 			"GraphQL-Features": "octoshift_github_owned_storage",
 		},
+		Transport: transport.RoundTripper(),
 	}
 
 	client, err := api.NewGraphQLClient(opts)
@@ -145,53 +189,209 @@ func QueryAllBlobsFromGitHub(orgName string) (*AllBlobsQuery, error) {
 	return &query, nil
 }
 
-func UploadArchiveToGitHub(ctx context.Context, input UploadArchiveInput) (*UploadArchiveResponse, error) {
-	archiveFilePath := input.ArchiveFilePath
-	orgId := input.OrganizationId
+// ListMigrationArchives pages through the organization's migration archives
+// starting after the given cursor (empty for the beginning) without logging
+// each blob. It returns the archives and the cursor of the last page.
+func ListMigrationArchives(orgName string, after string) ([]MigrationArchive, string, error) {
+	opts := api.ClientOptions{
+		Headers: map[string]string{
+			"Accept":           "application/json",
+			"GraphQL-Features": "octoshift_github_owned_storage",
+		},
+		Transport: transport.RoundTripper(),
+	}
 
-	// Open the file
-	reader, err := os.Open(archiveFilePath)
+	client, err := api.NewGraphQLClient(opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+		return nil, "", fmt.Errorf("failed to create GitHub client: %v", err)
 	}
 
-	currentPos, err := reader.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return nil, logAndReturnError(archiveFilePath, fmt.Errorf("failed to get current position: %w", err))
+	var query AllBlobsQuery
+
+	variables := map[string]interface{}{
+		"login":     graphql.String(orgName),
+		"first":     graphql.Int(100),
+		"endCursor": (*graphql.String)(nil),
+	}
+	if after != "" {
+		variables["endCursor"] = graphql.String(after)
 	}
 
-	size, err := reader.Seek(0, io.SeekEnd)
-	if err != nil {
-		return nil, logAndReturnError(archiveFilePath, fmt.Errorf("failed to determine file size: %w", err))
+	var archives []MigrationArchive
+	endCursor := after
+	for {
+		err = withRateLimitRetry(func() error {
+			query = AllBlobsQuery{}
+			return client.Query("AllBlobs", &query, variables)
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to query GitHub API: %w", err)
+		}
+		archives = append(archives, query.Organization.MigrationArchives.Nodes...)
+		if query.Organization.MigrationArchives.PageInfo.EndCursor != "" {
+			endCursor = query.Organization.MigrationArchives.PageInfo.EndCursor
+		}
+
+		if !query.Organization.MigrationArchives.PageInfo.HasNextPage {
+			break
+		}
+		variables["endCursor"] = graphql.String(query.Organization.MigrationArchives.PageInfo.EndCursor)
 	}
 
-	_, err = reader.Seek(currentPos, io.SeekStart)
+	return archives, endCursor, nil
+}
+
+// ListEnterpriseOrganizations returns the logins of every organization in
+// the enterprise with the given slug.
+func ListEnterpriseOrganizations(slug string) ([]string, error) {
+	opts := api.ClientOptions{
+		Headers:   map[string]string{"Accept": "application/json"},
+		Transport: transport.RoundTripper(),
+	}
+
+	client, err := api.NewGraphQLClient(opts)
 	if err != nil {
-		return nil, logAndReturnError(archiveFilePath, fmt.Errorf("failed to reset file position: %w", err))
+		return nil, fmt.Errorf("failed to create GitHub client: %v", err)
+	}
+
+	var query EnterpriseOrgsQuery
+
+	variables := map[string]interface{}{
+		"slug":      graphql.String(slug),
+		"first":     graphql.Int(100),
+		"endCursor": (*graphql.String)(nil),
+	}
+
+	var logins []string
+	for {
+		err = withRateLimitRetry(func() error {
+			query = EnterpriseOrgsQuery{}
+			return client.Query("EnterpriseOrganizations", &query, variables)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query GitHub API: %w", err)
+		}
+		for _, org := range query.Enterprise.Organizations.Nodes {
+			logins = append(logins, org.Login)
+		}
+
+		if !query.Enterprise.Organizations.PageInfo.HasNextPage {
+			break
+		}
+		variables["endCursor"] = graphql.String(query.Enterprise.Organizations.PageInfo.EndCursor)
 	}
 
-	var uploadArchiveResponse *UploadArchiveResponse
+	return logins, nil
+}
+
+// UploadArchiveToGitHub uploads the archive at input.ArchiveFilePath, or
+// input.Reader when it is set, to GitHub-owned storage.
+func UploadArchiveToGitHub(ctx context.Context, input UploadArchiveInput) (*UploadArchiveResponse, error) {
+	archiveFilePath := input.ArchiveFilePath
+	orgId := input.OrganizationId
+	blobName := input.Name
+	if blobName == "" {
+		blobName = filepath.Base(archiveFilePath)
+	}
+
+	log := ghlog.Logger.With(
+		zap.String("org", input.Organization),
+		zap.String("orgId", orgId),
+		zap.String("blobName", blobName))
+
+	reader := input.Reader
+	size := input.Size
+	var modTime time.Time
+	if reader == nil {
+		// Open the file
+		file, err := os.Open(archiveFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
+
+		currentPos, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, logAndReturnError(archiveFilePath, fmt.Errorf("failed to get current position: %w", err))
+		}
+
+		size, err = file.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, logAndReturnError(archiveFilePath, fmt.Errorf("failed to determine file size: %w", err))
+		}
+
+		_, err = file.Seek(currentPos, io.SeekStart)
+		if err != nil {
+			return nil, logAndReturnError(archiveFilePath, fmt.Errorf("failed to reset file position: %w", err))
+		}
+		if info, err := file.Stat(); err == nil {
+			modTime = info.ModTime()
+		}
+		reader = file
+	}
+
+	if size < 0 {
+		return nil, fmt.Errorf("the size of %s must be known before it is uploaded", blobName)
+	}
 	if size < DefaultMultipartThreshold {
-		uploadArchiveResponse, err = simpleUpload(ctx, orgId, reader, size)
+		// The body is sent once, so it can be hashed as it is read
+		if input.Hash != nil {
+			reader = io.TeeReader(reader, input.Hash)
+		}
+		return simpleUpload(ctx, log, orgId, blobName, reader, size, input.Limiter, input.StallTimeout)
+	}
+	partSize := input.PartSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+
+	opts := multipartOptions{
+		limiter:       input.Limiter,
+		abortOnCancel: input.AbortOnCancel,
+		partTimeout:   input.PartTimeout,
+		stallTimeout:  input.StallTimeout,
+	}
+	if input.Hash != nil {
+		hash, err := newPartHash(input.Hash)
 		if err != nil {
 			return nil, err
 		}
-		return uploadArchiveResponse, nil
-	} else {
-		uploadArchiveResponse, err = multipartUpload(ctx, orgId, reader, size)
+		opts.hash = hash
+	}
+	// Only uploads of a file can be resumed; a stream cannot be replayed
+	if input.Reader == nil {
+		absPath, err := filepath.Abs(archiveFilePath)
 		if err != nil {
-			return nil, err
+			absPath = archiveFilePath
+		}
+		opts.state = &UploadState{
+			FilePath: absPath,
+			ModTime:  modTime,
+			OrgId:    orgId,
+			BlobName: blobName,
+			Size:     size,
+			PartSize: partSize,
+		}
+		if input.Resume {
+			saved, err := LoadUploadState(absPath, orgId)
+			switch {
+			case err != nil:
+				log.Warn("failed to load upload state, starting a new upload", zap.Error(err))
+			case saved == nil:
+				log.Info("No interrupted upload of this file found, starting a new upload")
+			case !saved.matches(blobName, size, modTime):
+				log.Warn("The file changed since the upload was interrupted, starting a new upload")
+			default:
+				opts.state = saved
+				partSize = saved.PartSize
+			}
 		}
-		return uploadArchiveResponse, nil
 	}
-
+	return multipartUpload(ctx, log, orgId, blobName, reader, size, partSize, opts)
 }
 
-func simpleUpload(ctx context.Context, orgId string, reader io.ReadSeeker, size int64) (*UploadArchiveResponse, error) {
-	ghlog.Logger.Info("Uploading file to GitHub",
-		zap.String("orgId", fmt.Sprintf("%v", orgId)))
-
-	blobName := filepath.Base(reader.(*os.File).Name())
+func simpleUpload(ctx context.Context, log *zap.Logger, orgId string, blobName string, reader io.Reader, size int64, limiter *throttle.Limiter, stallTimeout time.Duration) (*UploadArchiveResponse, error) {
+	log.Info("Uploading file to GitHub")
 
 	// Create a new GitHub client
 	githubClient := clients.NewGitHubClient(os.Getenv("GITHUB_TOKEN"))
@@ -201,8 +401,10 @@ func simpleUpload(ctx context.Context, orgId string, reader io.ReadSeeker, size
 	}
 
 	// Upload the file
-	url := fmt.Sprintf("https://uploads.github.com/organizations/%s/gei/archive?name=%s", orgId, blobName)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, reader)
+	url := fmt.Sprintf("%s/organizations/%s/gei/archive?name=%s", uploadsURL, orgId, neturl.QueryEscape(blobName))
+	ctx, stall, stopStall := watchStall(ctx, stallTimeout)
+	defer stopStall()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, stall.Reader(limiter.Reader(ctx, reader)))
 	if err != nil {
 		return nil, logAndReturnError(blobName, fmt.Errorf("failed to create HTTP request: %w", err))
 	}
@@ -214,11 +416,14 @@ func simpleUpload(ctx context.Context, orgId string, reader io.ReadSeeker, size
 
 	resp, err := client.Client().Do(req)
 	if err != nil {
+		if context.Cause(ctx) == errStalled {
+			err = fmt.Errorf("%w: no progress for %s", errStalled, stallTimeout)
+		}
 		return nil, logAndReturnError(blobName, fmt.Errorf("failed to upload file: %w", err))
 	}
 	defer func() {
 		if err := resp.Body.Close(); err != nil {
-			ghlog.Logger.Error("failed to close response body", zap.Error(err))
+			log.Error("failed to close response body", zap.Error(err))
 		}
 	}()
 
@@ -233,7 +438,7 @@ func simpleUpload(ctx context.Context, orgId string, reader io.ReadSeeker, size
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		ghlog.Logger.Error("Failed to read response body", zap.Error(err))
+		log.Error("Failed to read response body", zap.Error(err))
 		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
 
@@ -241,18 +446,32 @@ func simpleUpload(ctx context.Context, orgId string, reader io.ReadSeeker, size
 
 	// unmarshal the response
 	if err := json.Unmarshal(body, &uploadArchiveResponse); err != nil {
-		ghlog.Logger.Error("Failed to decode response", zap.Error(err))
+		log.Error("Failed to decode response", zap.Error(err))
 		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
 
 	return &uploadArchiveResponse, nil
 }
 
-func multipartUpload(ctx context.Context, orgId string, reader io.ReadSeeker, size int64) (*UploadArchiveResponse, error) {
-	ghlog.Logger.Info("Uploading file to GitHub",
-		zap.String("orgId", fmt.Sprintf("%v", orgId)))
+// multipartOptions are the optional behaviours of multipartUpload.
+type multipartOptions struct {
+	limiter *throttle.Limiter
+	// state, when set, is saved after every part so the upload can be
+	// resumed. A state with a NextLocation continues that session.
+	state *UploadState
+	// abortOnCancel ends the session on GitHub when the upload is
+	// interrupted instead of keeping it for a resume.
+	abortOnCancel bool
+	// partTimeout bounds each part request and stallTimeout how long a part
+	// may go without progress; zero disables either.
+	partTimeout  time.Duration
+	stallTimeout time.Duration
+	// hash, when set, is fed each part once it is sent.
+	hash *partHash
+}
 
-	blobName := filepath.Base(reader.(*os.File).Name())
+func multipartUpload(ctx context.Context, log *zap.Logger, orgId string, blobName string, reader io.Reader, size int64, chunkSize int64, opts multipartOptions) (*UploadArchiveResponse, error) {
+	log.Info("Uploading file to GitHub")
 
 	// Create a new GitHub client
 	githubClient := clients.NewGitHubClient(os.Getenv("GITHUB_TOKEN"))
@@ -261,52 +480,17 @@ func multipartUpload(ctx context.Context, orgId string, reader io.ReadSeeker, si
 		return nil, fmt.Errorf("failed to create GitHub client: %v", err)
 	}
 
-	// Prepare JSON body
-	bodyData := map[string]interface{}{
-		"content_type": "application/octet-stream",
-		"name":         blobName,
-		"size":         size,
-	}
-	jsonBody, err := json.Marshal(bodyData)
-	if err != nil {
-		return nil, logAndReturnError(blobName, fmt.Errorf("failed to marshal JSON body: %w", err))
-	}
-
-	// Start the upload
-	url := fmt.Sprintf("https://uploads.github.com/organizations/%s/gei/archive/blobs/uploads", orgId)
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonBody))
-	if err != nil {
-		return nil, logAndReturnError(blobName, fmt.Errorf("failed to create HTTP request: %w", err))
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "gh-blob")
-	req.Header.Set("GraphQL-Features", "octoshift_github_owned_storage")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("GITHUB_TOKEN")))
-
-	resp, err := client.Client().Do(req)
-	if err != nil {
-		return nil, logAndReturnError(blobName, fmt.Errorf("failed to upload file: %w", err))
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			ghlog.Logger.Error("failed to close response body", zap.Error(err))
-		}
-	}()
-
-	if resp.StatusCode != http.StatusAccepted {
-		body, err := io.ReadAll(resp.Body)
+	// Parts already sent are kept by GitHub, so a resumed upload continues
+	// the saved session from its next part
+	resuming := opts.state != nil && opts.state.NextLocation != ""
+	var location string
+	if resuming {
+		location = opts.state.NextLocation
+	} else {
+		location, err = startMultipartUpload(ctx, client.Client(), log, orgId, blobName, size)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read response body: %v", err)
+			return nil, err
 		}
-		defer resp.Body.Close()
-		return nil, fmt.Errorf("unexpected response status: %d, body: %s", resp.StatusCode, string(body))
-	}
-
-	// get the Location header from the response
-	location := resp.Header.Get("Location")
-	if location == "" {
-		return nil, fmt.Errorf("missing Location header in response")
 	}
 	//ghlog.Logger.Info("Location header: " + location)
 	// The location looks like this: /organizations/{organization_id}/gei/archive/blobs/uploads?part_number=1&guid=<guid>&upload_id=<upload_id>
@@ -327,73 +511,111 @@ func multipartUpload(ctx context.Context, orgId string, reader io.ReadSeeker, si
 		}
 	}
 
-	ghlog.Logger.Info("Upload ID: " + uploadId)
-	ghlog.Logger.Info("GUID: " + guid)
+	log = log.With(zap.String("guid", guid))
+	if resuming {
+		log.Info("Resuming multipart upload",
+			zap.String("uploadId", uploadId),
+			zap.Int("part", opts.state.PartNumber),
+			zap.Int64("uploadedBytes", opts.state.Uploaded))
+	} else {
+		log.Info("Started multipart upload", zap.String("uploadId", uploadId))
+	}
+
+	// Parts are streamed from the file through a SectionReader so memory
+	// stays flat regardless of the part size, a short read can never produce
+	// a short part, and a replayed request re-reads the part from its offset.
+	// A stream without ReadAt is read one part at a time into a buffer
+	// instead, which keeps the part replayable.
+	readerAt, seekable := reader.(io.ReaderAt)
+	var partBuffer []byte
+	if !seekable {
+		partBuffer = make([]byte, chunkSize)
+	}
 
-	// Upload file in parts of DefaultPartSize (100 MiB)
+	// Upload file in parts of chunkSize (DefaultPartSize, 100 MiB, unless configured)
 	partNumber := 1
 	var lastLocation string = location
 	var nextLocation string = location
 	var uploadedBytes int64 = 0
+	if resuming {
+		partNumber = opts.state.PartNumber
+		lastLocation = opts.state.LastLocation
+		uploadedBytes = opts.state.Uploaded
+		// The parts sent before the interruption were hashed by another run
+		if opts.hash != nil && seekable {
+			if _, err := io.Copy(opts.hash.hash, io.NewSectionReader(readerAt, 0, uploadedBytes)); err != nil {
+				return nil, fmt.Errorf("failed to hash the uploaded parts: %v", err)
+			}
+		}
+	}
+
+	// A part in flight when ctx is cancelled is allowed to finish, so parts
+	// and the finalization use a context that only keeps ctx's deadline.
+	partCtx, cancelParts := detachedContext(ctx)
+	defer cancelParts()
 
 	for uploadedBytes < size {
-		ghlog.Logger.Info(fmt.Sprintf("Uploading part %d", partNumber))
+		if ctx.Err() != nil {
+			return nil, interruptMultipartUpload(client.Client(), log, nextLocation, uploadedBytes, size, opts)
+		}
+
 		// Calculate the size of this part
-		partSize := DefaultPartSize
+		partSize := chunkSize
 		if size-uploadedBytes < partSize {
 			partSize = size - uploadedBytes
 		}
 
-		// Read the part into memory
-		partBuf := make([]byte, partSize)
-		n, err := reader.Read(partBuf)
-		if err != nil && err != io.EOF {
-			return nil, fmt.Errorf("failed to read file part: %v", err)
-		}
-		if int64(n) != partSize {
-			partBuf = partBuf[:n]
-		}
-
 		// PATCH request to upload this part
-		uploadURL := "https://uploads.github.com" + nextLocation
-		req, err := http.NewRequestWithContext(ctx, "PATCH", uploadURL, bytes.NewReader(partBuf))
-		if err != nil {
-			return nil, fmt.Errorf("failed to create PATCH request: %v", err)
+		partReader, partOffset := readerAt, uploadedBytes
+		if !seekable {
+			if _, err := io.ReadFull(reader, partBuffer[:partSize]); err != nil {
+				return nil, fmt.Errorf("failed to read part %d: %v", partNumber, err)
+			}
+			partReader, partOffset = bytes.NewReader(partBuffer[:partSize]), 0
 		}
-		req.Header.Set("Content-Type", "application/octet-stream")
-		req.Header.Set("User-Agent", "gh-blob")
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("GITHUB_TOKEN")))
-		req.Header.Set("GraphQL-Features", "octoshift_github_owned_storage")
-		req.ContentLength = int64(len(partBuf))
-
-		resp, err := client.Client().Do(req)
-		if err != nil {
-			return nil, fmt.Errorf("failed to upload part %d: %v", partNumber, err)
+		log.Info("Uploading part", zap.Int("part", partNumber))
+		if opts.hash != nil {
+			if err := opts.hash.begin(); err != nil {
+				return nil, err
+			}
 		}
-		if resp.StatusCode != http.StatusAccepted {
-			body, _ := io.ReadAll(resp.Body)
-			resp.Body.Close()
-			return nil, fmt.Errorf("unexpected response status for part %d: %d, body: %s", partNumber, resp.StatusCode, string(body))
+		next, err := uploadPart(ctx, partCtx, client.Client(), log, nextLocation, partNumber, partReader, partOffset, partSize, opts)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, interruptMultipartUpload(client.Client(), log, nextLocation, uploadedBytes, size, opts)
+			}
+			return nil, err
 		}
 		// Save the previous location for the finalization step
 		lastLocation = nextLocation
 		// Get the next location from the response header
-		nextLocation = resp.Header.Get("Location")
-		resp.Body.Close()
+		nextLocation = next
 
-		uploadedBytes += int64(n)
+		uploadedBytes += partSize
 		partNumber++
 
+		if opts.state != nil {
+			opts.state.GUID = guid
+			opts.state.UploadID = uploadId
+			opts.state.NextLocation = nextLocation
+			opts.state.LastLocation = lastLocation
+			opts.state.Uploaded = uploadedBytes
+			opts.state.PartNumber = partNumber
+			if err := opts.state.Save(); err != nil {
+				log.Warn("failed to save upload state, the upload cannot be resumed", zap.Error(err))
+			}
+		}
+
 		// If this is the last part, break the loop
 		if uploadedBytes >= size || nextLocation == "" {
 			break
 		}
 	}
 
-	ghlog.Logger.Info("Finalizing upload...")
+	log.Info("Finalizing upload...")
 	// Finalize the upload by sending a POST to the last location
-	finalizeURL := "https://uploads.github.com" + lastLocation
-	finalizeReq, err := http.NewRequestWithContext(ctx, "PUT", finalizeURL, nil)
+	finalizeURL := uploadsURL + lastLocation
+	finalizeReq, err := http.NewRequestWithContext(partCtx, "PUT", finalizeURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create finalize request: %v", err)
 	}
@@ -422,13 +644,130 @@ func multipartUpload(ctx context.Context, orgId string, reader io.ReadSeeker, si
 
 	// unmarshal the response
 	if err := json.Unmarshal(body, &uploadArchiveResponse); err != nil {
-		ghlog.Logger.Error("Failed to decode response", zap.Error(err))
+		log.Error("Failed to decode response", zap.Error(err))
 		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
 
+	if opts.state != nil {
+		if err := opts.state.Remove(); err != nil {
+			log.Warn("failed to remove upload state", zap.Error(err))
+		}
+	}
 	return &uploadArchiveResponse, nil
 }
 
+// interruptMultipartUpload stops an upload whose context was cancelled. The
+// session is kept for a resume unless opts.abortOnCancel is set, in which
+// case the saved state is removed and the session is aborted on GitHub. The
+// upload API does not document aborting a session, so the DELETE is best
+// effort: any response other than 2xx means the session may still exist.
+func interruptMultipartUpload(httpClient *http.Client, log *zap.Logger, nextLocation string, uploaded, size int64, opts multipartOptions) error {
+	err := fmt.Errorf("%w after %d of %d bytes", ErrUploadInterrupted, uploaded, size)
+
+	if !opts.abortOnCancel {
+		if opts.state != nil && opts.state.NextLocation != "" {
+			log.Warn("Upload interrupted, run the same upload with --resume to continue",
+				zap.Int64("uploadedBytes", uploaded))
+		} else {
+			log.Warn("Upload interrupted", zap.Int64("uploadedBytes", uploaded))
+		}
+		return err
+	}
+
+	// The command context is already cancelled, so give the abort its own
+	// short deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, reqErr := http.NewRequestWithContext(ctx, "DELETE", uploadsURL+nextLocation, nil)
+	if reqErr == nil {
+		req.Header.Set("User-Agent", "gh-blob")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("GITHUB_TOKEN")))
+		req.Header.Set("GraphQL-Features", "octoshift_github_owned_storage")
+		var resp *http.Response
+		resp, reqErr = httpClient.Do(req)
+		if reqErr == nil {
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+				reqErr = fmt.Errorf("unexpected response status: %d", resp.StatusCode)
+			}
+		}
+	}
+	if reqErr != nil {
+		log.Warn("Upload session was not aborted and may remain on GitHub until it expires; aborting is best effort", zap.Error(reqErr))
+	} else {
+		log.Info("Aborted upload session")
+	}
+	if opts.state != nil {
+		if err := opts.state.Remove(); err != nil {
+			log.Warn("failed to remove upload state", zap.Error(err))
+		}
+	}
+	return err
+}
+
+// detachedContext returns a context that is not cancelled with ctx but
+// keeps its deadline.
+func detachedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	detached := context.WithoutCancel(ctx)
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(detached, deadline)
+	}
+	return context.WithCancel(detached)
+}
+
+// startMultipartUpload starts an upload session and returns the location to
+// send the first part to.
+func startMultipartUpload(ctx context.Context, httpClient *http.Client, log *zap.Logger, orgId string, blobName string, size int64) (string, error) {
+	// Prepare JSON body
+	bodyData := map[string]interface{}{
+		"content_type": "application/octet-stream",
+		"name":         blobName,
+		"size":         size,
+	}
+	jsonBody, err := json.Marshal(bodyData)
+	if err != nil {
+		return "", logAndReturnError(blobName, fmt.Errorf("failed to marshal JSON body: %w", err))
+	}
+
+	// Start the upload
+	url := fmt.Sprintf("%s/organizations/%s/gei/archive/blobs/uploads", uploadsURL, orgId)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return "", logAndReturnError(blobName, fmt.Errorf("failed to create HTTP request: %w", err))
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "gh-blob")
+	req.Header.Set("GraphQL-Features", "octoshift_github_owned_storage")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("GITHUB_TOKEN")))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", logAndReturnError(blobName, fmt.Errorf("failed to upload file: %w", err))
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Error("failed to close response body", zap.Error(err))
+		}
+	}()
+
+	if resp.StatusCode != http.StatusAccepted {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", fmt.Errorf("failed to read response body: %v", err)
+		}
+		defer resp.Body.Close()
+		return "", fmt.Errorf("unexpected response status: %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	// get the Location header from the response
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("missing Location header in response")
+	}
+	return location, nil
+}
+
 func DeleteBlobFromGitHub(id string) error {
 	ghlog.Logger.Info("Deleting blob from GitHub",
 		zap.String("id", id))
@@ -519,12 +858,51 @@ func DeleteBlobFromGitHub(id string) error {
 	// Check for GraphQL errors
 	if len(response.Errors) > 0 {
 		errMsg := response.Errors[0].Message
+		if response.Errors[0].Type == "NOT_FOUND" {
+			return fmt.Errorf("%w: %s", ErrBlobNotFound, errMsg)
+		}
 		return fmt.Errorf("GraphQL error: %s", errMsg)
 	}
 
 	return nil
 }
 
+// newPartRequest builds a PATCH request whose body streams length bytes of r
+// starting at offset. GetBody hands out a fresh SectionReader so the transport
+// can replay the part from its offset without buffering it. Both go through
+// limiter, which is shared by all parts, and feed the part to hash, which
+// starts over from the state before the part on every replay.
+func newPartRequest(ctx context.Context, url string, r io.ReaderAt, offset, length int64, limiter *throttle.Limiter, stall *stallTimer, hash *partHash) (*http.Request, error) {
+	body := func() (io.Reader, error) {
+		var part io.Reader = io.NewSectionReader(r, offset, length)
+		if hash != nil {
+			w, err := hash.restart()
+			if err != nil {
+				return nil, err
+			}
+			part = io.TeeReader(part, w)
+		}
+		return stall.Reader(limiter.Reader(ctx, part)), nil
+	}
+	first, err := body()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PATCH", url, first)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = length
+	req.GetBody = func() (io.ReadCloser, error) {
+		replay, err := body()
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(replay), nil
+	}
+	return req, nil
+}
+
 func logAndReturnError(blobName string, err error) error {
 	ghlog.Logger.Error("GitHub upload operation failed",
 		zap.String("blobName", blobName),
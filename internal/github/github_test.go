@@ -0,0 +1,31 @@
+package github
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cli/go-gh/v2/pkg/api"
+)
+
+func TestIsNodeNotFound(t *testing.T) {
+	notFound := &api.GraphQLError{Errors: []api.GraphQLErrorItem{
+		{Type: "NOT_FOUND", Path: []interface{}{"node"}, Message: "Could not resolve to a node"},
+	}}
+	forbidden := &api.GraphQLError{Errors: []api.GraphQLErrorItem{
+		{Type: "FORBIDDEN", Path: []interface{}{"node"}},
+	}}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("failed to query GitHub API: %w", notFound), true},
+		{fmt.Errorf("failed to query GitHub API: %w", forbidden), false},
+		{errors.New("connection reset by peer"), false},
+	}
+	for _, tt := range tests {
+		if got := IsNodeNotFound(tt.err); got != tt.want {
+			t.Errorf("IsNodeNotFound(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package github
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ArchiveSelector identifies a migration archive by something other than its
+// node ID. Exactly one field is expected to be set.
+type ArchiveSelector struct {
+	GUID string
+	Name string
+	URI  string
+}
+
+// IsZero reports whether no field of the selector is set.
+func (s ArchiveSelector) IsZero() bool {
+	return s.GUID == "" && s.Name == "" && s.URI == ""
+}
+
+// Matches reports whether archive is selected by s.
+func (s ArchiveSelector) Matches(archive MigrationArchive) bool {
+	switch {
+	case s.GUID != "":
+		return strings.EqualFold(archive.GUID, s.GUID)
+	case s.URI != "":
+		return archive.URI == s.URI
+	case s.Name != "":
+		return archive.Name == s.Name
+	}
+	return false
+}
+
+func (s ArchiveSelector) String() string {
+	switch {
+	case s.GUID != "":
+		return "GUID " + s.GUID
+	case s.URI != "":
+		return "URI " + s.URI
+	case s.Name != "":
+		return "name " + s.Name
+	}
+	return "empty selector"
+}
+
+// FindMigrationArchives returns the archives of the organization selected by
+// selector.
+func FindMigrationArchives(orgName string, selector ArchiveSelector) ([]MigrationArchive, error) {
+	archives, _, err := ListMigrationArchives(orgName, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []MigrationArchive
+	for _, archive := range archives {
+		if selector.Matches(archive) {
+			matches = append(matches, archive)
+		}
+	}
+	return matches, nil
+}
+
+// ResolveArchiveID returns the node ID of the single archive of the
+// organization selected by selector. Names are not unique, so it is an error
+// for the selector to match more than one archive.
+func ResolveArchiveID(orgName string, selector ArchiveSelector) (string, error) {
+	matches, err := FindMigrationArchives(orgName, selector)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up archive by %s: %w", selector, err)
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no archive with %s found in organization %s", selector, orgName)
+	case 1:
+		return matches[0].ID, nil
+	}
+
+	candidates := make([]string, 0, len(matches))
+	for _, archive := range matches {
+		candidates = append(candidates, fmt.Sprintf("%s (created %s)", archive.ID, archive.CreatedAt))
+	}
+	return "", fmt.Errorf("%d archives with %s found in organization %s, use --id with one of: %s",
+		len(matches), selector, orgName, strings.Join(candidates, ", "))
+}
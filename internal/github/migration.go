@@ -0,0 +1,385 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cli/go-gh/v2/pkg/api"
+	"github.com/robandpdx/gh-blob/internal/transport"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+// Repository migration states as reported by the GraphQL API.
+const (
+	MigrationStateQueued            = "QUEUED"
+	MigrationStateInProgress        = "IN_PROGRESS"
+	MigrationStateNotStarted        = "NOT_STARTED"
+	MigrationStatePendingValidation = "PENDING_VALIDATION"
+	MigrationStateFailedValidation  = "FAILED_VALIDATION"
+	MigrationStateFailed            = "FAILED"
+	MigrationStateSucceeded         = "SUCCEEDED"
+)
+
+const (
+	DefaultMigrationPollInterval     = 30 * time.Second
+	migrationSourceTypeGitHubArchive = "GITHUB_ARCHIVE"
+)
+
+// IsActiveMigrationState reports whether a migration in state may still
+// read its archives.
+func IsActiveMigrationState(state string) bool {
+	switch state {
+	case MigrationStateQueued, MigrationStateInProgress, MigrationStateNotStarted, MigrationStatePendingValidation:
+		return true
+	}
+	return false
+}
+
+// IsTerminalMigrationState reports whether a migration in state will not
+// change any more.
+func IsTerminalMigrationState(state string) bool {
+	switch state {
+	case MigrationStateSucceeded, MigrationStateFailed, MigrationStateFailedValidation:
+		return true
+	}
+	return false
+}
+
+type StartMigrationInput struct {
+	SourceId             string
+	OwnerId              string
+	SourceRepositoryUrl  string
+	RepositoryName       string
+	GitArchiveUrl        string
+	MetadataArchiveUrl   string
+	TargetRepoVisibility string
+}
+
+type RepositoryMigration struct {
+	ID              string `json:"id"`
+	RepositoryName  string `json:"repositoryName"`
+	SourceUrl       string `json:"sourceUrl"`
+	State           string `json:"state"`
+	FailureReason   string `json:"failureReason"`
+	MigrationLogUrl string `json:"migrationLogUrl"`
+	WarningsCount   int    `json:"warningsCount"`
+	CreatedAt       string `json:"createdAt"`
+}
+
+func newMigrationClient() (*api.GraphQLClient, error) {
+	opts := api.ClientOptions{
+		Headers: map[string]string{
+			"Accept":           "application/json",
+			"GraphQL-Features": "import_api,mannequin_claiming,octoshift_github_owned_storage",
+		},
+		Transport: transport.RoundTripper(),
+	}
+
+	client, err := api.NewGraphQLClient(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GitHub client: %v", err)
+	}
+	return client, nil
+}
+
+// CreateMigrationSource creates a GitHub archive migration source owned by
+// the organization with the given node ID and returns the source's ID.
+func CreateMigrationSource(ownerId string, name string) (string, error) {
+	client, err := newMigrationClient()
+	if err != nil {
+		return "", err
+	}
+
+	mutation := `
+	mutation createMigrationSource($name: String!, $ownerId: ID!, $type: MigrationSourceType!) {
+		createMigrationSource(input: {name: $name, url: "https://github.com", ownerId: $ownerId, type: $type}) {
+			migrationSource {
+				id
+			}
+		}
+	}`
+
+	var response struct {
+		CreateMigrationSource struct {
+			MigrationSource struct {
+				ID string `json:"id"`
+			} `json:"migrationSource"`
+		} `json:"createMigrationSource"`
+	}
+	variables := map[string]interface{}{
+		"name":    name,
+		"ownerId": ownerId,
+		"type":    migrationSourceTypeGitHubArchive,
+	}
+	if err := client.Do(mutation, variables, &response); err != nil {
+		return "", fmt.Errorf("failed to create migration source: %w", err)
+	}
+
+	ghlog.Logger.Info("Created migration source",
+		zap.String("sourceId", response.CreateMigrationSource.MigrationSource.ID))
+	return response.CreateMigrationSource.MigrationSource.ID, nil
+}
+
+// StartRepositoryMigration starts migrating a repository from uploaded git
+// and metadata archives and returns the new migration.
+func StartRepositoryMigration(input StartMigrationInput) (*RepositoryMigration, error) {
+	client, err := newMigrationClient()
+	if err != nil {
+		return nil, err
+	}
+
+	mutation := `
+	mutation startRepositoryMigration(
+		$sourceId: ID!
+		$ownerId: ID!
+		$sourceRepositoryUrl: URI!
+		$repositoryName: String!
+		$continueOnError: Boolean!
+		$gitArchiveUrl: String
+		$metadataArchiveUrl: String
+		$accessToken: String!
+		$githubPat: String
+		$targetRepoVisibility: String
+	) {
+		startRepositoryMigration(
+			input: {
+				sourceId: $sourceId
+				ownerId: $ownerId
+				sourceRepositoryUrl: $sourceRepositoryUrl
+				repositoryName: $repositoryName
+				continueOnError: $continueOnError
+				gitArchiveUrl: $gitArchiveUrl
+				metadataArchiveUrl: $metadataArchiveUrl
+				accessToken: $accessToken
+				githubPat: $githubPat
+				targetRepoVisibility: $targetRepoVisibility
+			}
+		) {
+			repositoryMigration {
+				id
+				repositoryName
+				sourceUrl
+				state
+				failureReason
+				createdAt
+			}
+		}
+	}`
+
+	token := os.Getenv("GITHUB_TOKEN")
+	variables := map[string]interface{}{
+		"sourceId":             input.SourceId,
+		"ownerId":              input.OwnerId,
+		"sourceRepositoryUrl":  input.SourceRepositoryUrl,
+		"repositoryName":       input.RepositoryName,
+		"continueOnError":      true,
+		"gitArchiveUrl":        input.GitArchiveUrl,
+		"metadataArchiveUrl":   input.MetadataArchiveUrl,
+		"accessToken":          token,
+		"githubPat":            token,
+		"targetRepoVisibility": input.TargetRepoVisibility,
+	}
+
+	var response struct {
+		StartRepositoryMigration struct {
+			RepositoryMigration RepositoryMigration `json:"repositoryMigration"`
+		} `json:"startRepositoryMigration"`
+	}
+	if err := client.Do(mutation, variables, &response); err != nil {
+		return nil, fmt.Errorf("failed to start repository migration: %w", err)
+	}
+
+	return &response.StartRepositoryMigration.RepositoryMigration, nil
+}
+
+// ErrMigrationNotFound is returned by GetRepositoryMigration for an ID that
+// is not a migration.
+var ErrMigrationNotFound = errors.New("repository migration not found")
+
+// GetRepositoryMigration returns the current state of a migration.
+func GetRepositoryMigration(id string) (*RepositoryMigration, error) {
+	client, err := newMigrationClient()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	query getRepositoryMigration($id: ID!) {
+		node(id: $id) {
+			... on Migration {
+				id
+				repositoryName
+				sourceUrl
+				state
+				failureReason
+				migrationLogUrl
+				warningsCount
+				createdAt
+			}
+		}
+	}`
+
+	var response struct {
+		Node RepositoryMigration `json:"node"`
+	}
+	err = withRateLimitRetry(func() error {
+		return client.Do(query, map[string]interface{}{"id": id}, &response)
+	})
+	if IsNodeNotFound(err) || err == nil && response.Node.ID == "" {
+		return nil, fmt.Errorf("%w: %s", ErrMigrationNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query repository migration: %w", err)
+	}
+	return &response.Node, nil
+}
+
+// WaitForRepositoryMigration polls a migration every interval until it
+// reaches a terminal state or ctx is done.
+func WaitForRepositoryMigration(ctx context.Context, id string, interval time.Duration) (*RepositoryMigration, error) {
+	lastState := ""
+	for {
+		migration, err := GetRepositoryMigration(id)
+		if err != nil {
+			return nil, err
+		}
+		if migration.State != lastState {
+			ghlog.Logger.Info("Repository migration state changed",
+				zap.String("migrationId", id),
+				zap.String("repository", migration.RepositoryName),
+				zap.String("state", migration.State))
+			lastState = migration.State
+		}
+		if IsTerminalMigrationState(migration.State) {
+			return migration, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return migration, fmt.Errorf("stopped waiting for migration %s: %w", id, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
+
+// ListRepositoryMigrations returns every repository migration of the
+// organization, newest first.
+func ListRepositoryMigrations(orgName string) ([]RepositoryMigration, error) {
+	client, err := newMigrationClient()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	query listRepositoryMigrations($login: String!, $first: Int!, $endCursor: String) {
+		organization(login: $login) {
+			repositoryMigrations(first: $first, after: $endCursor, orderBy: {field: CREATED_AT, direction: DESC}) {
+				pageInfo {
+					hasNextPage
+					endCursor
+				}
+				nodes {
+					id
+					repositoryName
+					sourceUrl
+					state
+					failureReason
+					migrationLogUrl
+					warningsCount
+					createdAt
+				}
+			}
+		}
+	}`
+
+	variables := map[string]interface{}{
+		"login":     orgName,
+		"first":     100,
+		"endCursor": nil,
+	}
+
+	var migrations []RepositoryMigration
+	for {
+		var response struct {
+			Organization struct {
+				RepositoryMigrations struct {
+					PageInfo struct {
+						HasNextPage bool   `json:"hasNextPage"`
+						EndCursor   string `json:"endCursor"`
+					} `json:"pageInfo"`
+					Nodes []RepositoryMigration `json:"nodes"`
+				} `json:"repositoryMigrations"`
+			} `json:"organization"`
+		}
+		err = withRateLimitRetry(func() error {
+			return client.Do(query, variables, &response)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list repository migrations: %w", err)
+		}
+		migrations = append(migrations, response.Organization.RepositoryMigrations.Nodes...)
+
+		if !response.Organization.RepositoryMigrations.PageInfo.HasNextPage {
+			break
+		}
+		variables["endCursor"] = response.Organization.RepositoryMigrations.PageInfo.EndCursor
+	}
+
+	return migrations, nil
+}
+
+// ArchivesByMigration maps each migration ID to the archives it references.
+// The API does not return the archive URLs a migration was started with, so
+// archiveUris holds the URLs recorded for each migration ID when it was
+// started; migrations without a record have no archives.
+func ArchivesByMigration(migrations []RepositoryMigration, archives []MigrationArchive, archiveUris map[string][]string) map[string][]MigrationArchive {
+	byUri := map[string]MigrationArchive{}
+	for _, archive := range archives {
+		byUri[archive.URI] = archive
+	}
+
+	result := map[string][]MigrationArchive{}
+	for _, migration := range migrations {
+		for _, url := range archiveUris[migration.ID] {
+			if archive, ok := byUri[url]; ok {
+				result[migration.ID] = append(result[migration.ID], archive)
+			}
+		}
+	}
+	return result
+}
+
+// ActiveMigrationsUsing returns the queued or in-progress migrations of the
+// organization that read from the archive with the given URI, according to
+// the archive URLs recorded in archiveUris. It also returns the active
+// migrations with no recorded URLs, which may use the archive too.
+func ActiveMigrationsUsing(orgName string, uri string, archiveUris map[string][]string) ([]RepositoryMigration, []RepositoryMigration, error) {
+	migrations, err := ListRepositoryMigrations(orgName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var active, unknown []RepositoryMigration
+	for _, migration := range migrations {
+		if !IsActiveMigrationState(migration.State) {
+			continue
+		}
+		urls, ok := archiveUris[migration.ID]
+		if !ok {
+			unknown = append(unknown, migration)
+			continue
+		}
+		for _, url := range urls {
+			if url == uri {
+				active = append(active, migration)
+				break
+			}
+		}
+	}
+	return active, unknown, nil
+}
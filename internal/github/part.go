@@ -0,0 +1,177 @@
+package github
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const maxPartRetries = 3
+
+// partHash feeds the parts of a multipart upload to a hash in order. The
+// state of the hash before each part is kept, so a part that is retried or
+// replayed is hashed once.
+type partHash struct {
+	hash  hash.Hash
+	state []byte
+}
+
+// newPartHash returns a partHash for h, which must be able to save and
+// restore its state as the hashes of the standard library can.
+func newPartHash(h hash.Hash) (*partHash, error) {
+	if _, ok := h.(encoding.BinaryMarshaler); !ok {
+		return nil, fmt.Errorf("hash %T cannot save its state", h)
+	}
+	if _, ok := h.(encoding.BinaryUnmarshaler); !ok {
+		return nil, fmt.Errorf("hash %T cannot restore its state", h)
+	}
+	return &partHash{hash: h}, nil
+}
+
+// begin marks the start of the next part.
+func (p *partHash) begin() error {
+	state, err := p.hash.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("failed to save hash state: %w", err)
+	}
+	p.state = state
+	return nil
+}
+
+// restart rewinds the hash to the start of the current part and returns it
+// for the part's bytes to be written to.
+func (p *partHash) restart() (io.Writer, error) {
+	if err := p.hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(p.state); err != nil {
+		return nil, fmt.Errorf("failed to restore hash state: %w", err)
+	}
+	return p.hash, nil
+}
+
+// errStalled is the cause of a request cancelled because no bytes moved for
+// the stall timeout.
+var errStalled = errors.New("upload stalled")
+
+// stallTimer cancels a request when its body has not been read for the
+// timeout. Time spent waiting for the response counts as no progress.
+type stallTimer struct {
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+// watchStall returns a context that is cancelled with errStalled unless a
+// reader wrapped by the returned timer makes progress at least every
+// timeout. A zero timeout returns a nil timer, which watches nothing.
+func watchStall(ctx context.Context, timeout time.Duration) (context.Context, *stallTimer, context.CancelFunc) {
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, nil, cancel
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	t := &stallTimer{timeout: timeout}
+	t.timer = time.AfterFunc(timeout, func() { cancel(errStalled) })
+	return ctx, t, func() {
+		t.timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// Reader returns r with every successful read resetting the timer. A nil
+// timer returns r unchanged.
+func (t *stallTimer) Reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &stallReader{r: r, timer: t}
+}
+
+type stallReader struct {
+	r     io.Reader
+	timer *stallTimer
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.timer.Reset(r.timer.timeout)
+	}
+	return n, err
+}
+
+// uploadPart sends length bytes of r at offset as part partNumber to the
+// session at location and returns the location of the next part. A part
+// that stalls, times out, loses its connection or gets a server error is
+// retried with backoff; parts run on partCtx, while ctx being cancelled
+// stops any further attempt.
+func uploadPart(ctx, partCtx context.Context, httpClient *http.Client, log *zap.Logger, location string, partNumber int, r io.ReaderAt, offset, length int64, opts multipartOptions) (string, error) {
+	for attempt := 0; ; attempt++ {
+		next, retryable, err := patchPart(partCtx, httpClient, location, partNumber, r, offset, length, opts)
+		if err == nil {
+			return next, nil
+		}
+		if !retryable || attempt >= maxPartRetries || ctx.Err() != nil || partCtx.Err() != nil {
+			return "", err
+		}
+		wait := time.Duration(1<<attempt) * time.Second
+		log.Warn("Part failed, retrying",
+			zap.Int("part", partNumber),
+			zap.Int("attempt", attempt+1),
+			zap.Duration("wait", wait),
+			zap.Error(err))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return "", err
+		}
+	}
+}
+
+// patchPart makes a single attempt at sending a part and reports whether a
+// failure is worth retrying.
+func patchPart(ctx context.Context, httpClient *http.Client, location string, partNumber int, r io.ReaderAt, offset, length int64, opts multipartOptions) (string, bool, error) {
+	parent := ctx
+	if opts.partTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.partTimeout)
+		defer cancel()
+	}
+	ctx, stall, stopStall := watchStall(ctx, opts.stallTimeout)
+	defer stopStall()
+
+	req, err := newPartRequest(ctx, uploadsURL+location, r, offset, length, opts.limiter, stall, opts.hash)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create PATCH request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("User-Agent", "gh-blob")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("GITHUB_TOKEN")))
+	req.Header.Set("GraphQL-Features", "octoshift_github_owned_storage")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		switch {
+		case parent.Err() != nil:
+			return "", false, fmt.Errorf("failed to upload part %d: %v", partNumber, err)
+		case context.Cause(ctx) == errStalled:
+			return "", true, fmt.Errorf("part %d stalled: no progress for %s", partNumber, opts.stallTimeout)
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			return "", true, fmt.Errorf("part %d timed out after %s", partNumber, opts.partTimeout)
+		}
+		return "", true, fmt.Errorf("failed to upload part %d: %v", partNumber, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(resp.Body)
+		return "", resp.StatusCode >= http.StatusInternalServerError,
+			fmt.Errorf("unexpected response status for part %d: %d, body: %s", partNumber, resp.StatusCode, string(body))
+	}
+	return resp.Header.Get("Location"), false, nil
+}
@@ -0,0 +1,77 @@
+package github
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cli/go-gh/v2/pkg/api"
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+const (
+	maxRateLimitRetries = 5
+	maxRateLimitWait    = 5 * time.Minute
+)
+
+// rateLimitWait reports whether err is a primary or secondary rate limit
+// error and, if so, how long to wait before retrying.
+func rateLimitWait(err error, attempt int) (time.Duration, bool) {
+	backoff := time.Duration(1<<attempt) * time.Second
+
+	var graphqlErr *api.GraphQLError
+	if errors.As(err, &graphqlErr) {
+		for _, item := range graphqlErr.Errors {
+			if item.Type == "RATE_LIMITED" {
+				return backoff * 15, true
+			}
+		}
+		return 0, false
+	}
+
+	var httpErr *api.HTTPError
+	if !errors.As(err, &httpErr) {
+		return 0, false
+	}
+	if httpErr.StatusCode != http.StatusForbidden && httpErr.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+
+	if retryAfter, err := strconv.Atoi(httpErr.Headers.Get("Retry-After")); err == nil {
+		return time.Duration(retryAfter) * time.Second, true
+	}
+	if httpErr.Headers.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(httpErr.Headers.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return time.Until(time.Unix(reset, 0)) + time.Second, true
+		}
+	}
+	if httpErr.StatusCode == http.StatusTooManyRequests {
+		return backoff, true
+	}
+	return 0, false
+}
+
+// withRateLimitRetry runs op, sleeping and retrying it while GitHub reports
+// that a rate limit was hit.
+func withRateLimitRetry(op func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil {
+			return nil
+		}
+		wait, limited := rateLimitWait(err, attempt)
+		if !limited || attempt >= maxRateLimitRetries {
+			return err
+		}
+		if wait > maxRateLimitWait {
+			wait = maxRateLimitWait
+		}
+		ghlog.Logger.Warn("Rate limited by GitHub, waiting before retrying",
+			zap.Duration("wait", wait),
+			zap.Int("attempt", attempt+1))
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,105 @@
+package github
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/config"
+)
+
+// ErrUploadInterrupted is returned when an upload stops because its context
+// was cancelled, e.g. by Ctrl-C, before all parts were sent.
+var ErrUploadInterrupted = errors.New("upload interrupted")
+
+// UploadState records the progress of a multipart upload of a file so an
+// interrupted upload can continue from the next part of the same session.
+type UploadState struct {
+	FilePath     string    `json:"file_path"`
+	ModTime      time.Time `json:"mod_time"`
+	OrgId        string    `json:"org_id"`
+	BlobName     string    `json:"blob_name"`
+	Size         int64     `json:"size"`
+	PartSize     int64     `json:"part_size"`
+	GUID         string    `json:"guid"`
+	UploadID     string    `json:"upload_id"`
+	NextLocation string    `json:"next_location"`
+	LastLocation string    `json:"last_location"`
+	Uploaded     int64     `json:"uploaded"`
+	PartNumber   int       `json:"part_number"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func uploadStatePath(filePath, orgId string) (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(orgId + "\x00" + filePath))
+	return filepath.Join(dir, "uploads", hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// LoadUploadState returns the saved progress of uploading the file at
+// filePath to the org, or nil if there is none.
+func LoadUploadState(filePath, orgId string) (*UploadState, error) {
+	path, err := uploadStatePath(filePath, orgId)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload state: %v", err)
+	}
+	var state UploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse upload state %s: %v", path, err)
+	}
+	return &state, nil
+}
+
+// Save writes the state so the upload can be resumed.
+func (s *UploadState) Save() error {
+	path, err := uploadStatePath(s.FilePath, s.OrgId)
+	if err != nil {
+		return err
+	}
+	s.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode upload state: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create upload state directory: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write upload state: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Remove deletes the saved state once the upload is finished or aborted.
+func (s *UploadState) Remove() error {
+	path, err := uploadStatePath(s.FilePath, s.OrgId)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// matches reports whether the state belongs to an upload of the file as it
+// is now, so resuming cannot mix parts of two different files.
+func (s *UploadState) matches(blobName string, size int64, modTime time.Time) bool {
+	return s.BlobName == blobName && s.Size == size && s.ModTime.Equal(modTime) && s.NextLocation != ""
+}
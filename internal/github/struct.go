@@ -1,8 +1,17 @@
 package github
 
+import (
+	"hash"
+	"io"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/throttle"
+)
+
 type GraphQLResponse struct {
 	Data   interface{} `json:"data"`
 	Errors []struct {
+		Type    string `json:"type"`
 		Message string `json:"message"`
 	} `json:"errors,omitempty"`
 }
@@ -13,7 +22,29 @@ type QueryVariables struct {
 
 type UploadArchiveInput struct {
 	ArchiveFilePath string
+	Organization    string
 	OrganizationId  string
+	PartSize        int64
+	// Name, Reader and Size upload a stream instead of the file at
+	// ArchiveFilePath. Size must be the exact number of bytes Reader yields.
+	Name   string
+	Reader io.Reader
+	Size   int64
+	// Limiter throttles the request bodies; nil uploads at full speed.
+	Limiter *throttle.Limiter
+	// Resume continues an interrupted multipart upload of the same file.
+	// AbortOnCancel instead ends the session on GitHub when interrupted.
+	Resume        bool
+	AbortOnCancel bool
+	// PartTimeout bounds each part request of a multipart upload.
+	// StallTimeout aborts a request whose body has not moved for that long;
+	// stalled or timed out parts are retried. Zero disables either.
+	PartTimeout  time.Duration
+	StallTimeout time.Duration
+	// Hash, when set, is fed every byte of the archive in order while it is
+	// uploaded, so a checksum costs no extra read of the file. It must be
+	// able to save and restore its state, as crypto/sha256 can.
+	Hash hash.Hash
 }
 type UploadArchiveResponse struct {
 	GUID      string `json:"guid"`
@@ -32,6 +63,21 @@ type OrgQuery struct {
 	} `graphql:"organization(login: $login)"`
 }
 
+type MigrationArchive struct {
+	GUID      string `graphql:"guid" json:"guid"`
+	ID        string `graphql:"id" json:"id"`
+	Name      string `graphql:"name" json:"name"`
+	Size      int    `graphql:"size" json:"size"`
+	URI       string `graphql:"uri" json:"uri"`
+	CreatedAt string `graphql:"createdAt" json:"created_at"`
+}
+
+type ViewerQuery struct {
+	Viewer struct {
+		Login string `graphql:"login"`
+	} `graphql:"viewer"`
+}
+
 type AllBlobsQuery struct {
 	Organization struct {
 		Login             string `graphql:"login"`
@@ -43,14 +89,7 @@ type AllBlobsQuery struct {
 				HasNextPage bool
 				EndCursor   string
 			}
-			Nodes []struct {
-				GUID      string `graphql:"guid"`
-				ID        string `graphql:"id"`
-				Name      string `graphql:"name"`
-				Size      int    `graphql:"size"`
-				URI       string `graphql:"uri"`
-				CreatedAt string `graphql:"createdAt"`
-			}
+			Nodes []MigrationArchive
 		} `graphql:"migrationArchives(first: $first, after: $endCursor)"`
 	} `graphql:"organization(login: $login)"`
 }
@@ -67,3 +106,17 @@ type BlobQuery struct {
 		} `graphql:"... on MigrationArchive"`
 	} `graphql:"node(id: $id)"`
 }
+
+type EnterpriseOrgsQuery struct {
+	Enterprise struct {
+		Organizations struct {
+			PageInfo struct {
+				HasNextPage bool
+				EndCursor   string
+			}
+			Nodes []struct {
+				Login string `graphql:"login"`
+			}
+		} `graphql:"organizations(first: $first, after: $endCursor)"`
+	} `graphql:"enterprise(slug: $slug)"`
+}
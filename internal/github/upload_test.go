@@ -0,0 +1,215 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	ghlog "github.com/robandpdx/gh-blob/pkg/logger"
+
+	"go.uber.org/zap"
+)
+
+// uploadServer fakes the multipart upload API of uploads.github.com and
+// counts the bytes it receives.
+type uploadServer struct {
+	*httptest.Server
+	received atomic.Int64
+}
+
+// newUploadServer starts a fake upload API and points uploads at it until
+// the test ends.
+func newUploadServer(tb testing.TB) *uploadServer {
+	tb.Helper()
+	s := &uploadServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	tb.Cleanup(s.Close)
+
+	previous := uploadsURL
+	uploadsURL = s.URL
+	tb.Cleanup(func() { uploadsURL = previous })
+	tb.Setenv("GITHUB_TOKEN", "test-token")
+	if ghlog.Logger == nil {
+		ghlog.Logger = zap.NewNop()
+	}
+	return s
+}
+
+func (s *uploadServer) handle(w http.ResponseWriter, r *http.Request) {
+	const uploads = "/organizations/1/gei/archive/blobs/uploads"
+	switch r.Method {
+	case http.MethodPost:
+		w.Header().Set("Location", uploads+"?part_number=1&guid=guid&upload_id=upload")
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPatch:
+		n, err := io.Copy(io.Discard, r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.received.Add(n)
+		part, _ := strconv.Atoi(r.URL.Query().Get("part_number"))
+		w.Header().Set("Location", fmt.Sprintf("%s?part_number=%d&guid=guid&upload_id=upload", uploads, part+1))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(UploadArchiveResponse{
+			GUID:   "guid",
+			NodeID: "MA_test",
+			Name:   "archive.tar",
+			Size:   int(s.received.Load()),
+		})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// sparseFile creates a file of size bytes that takes no disk space.
+func sparseFile(tb testing.TB, size int64) *os.File {
+	tb.Helper()
+	f, err := os.Create(filepath.Join(tb.TempDir(), "archive.tar"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { f.Close() })
+	if err := f.Truncate(size); err != nil {
+		tb.Fatal(err)
+	}
+	return f
+}
+
+// BenchmarkMultipartUpload measures uploading a file in parts. The file case
+// streams each part from the file through a SectionReader; the stream case
+// hides ReadAt so every part is read into a buffer first, which is how files
+// were uploaded before parts were streamed. Compare allocations with
+//
+//	go test -run '^$' -bench MultipartUpload -benchmem ./internal/github
+func BenchmarkMultipartUpload(b *testing.B) {
+	const size = 128 << 20
+	const partSize = 16 << 20
+
+	for _, bc := range []struct {
+		name   string
+		reader func(f *os.File) io.Reader
+	}{
+		{"file", func(f *os.File) io.Reader { return f }},
+		{"stream", func(f *os.File) io.Reader { return struct{ io.Reader }{f} }},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			server := newUploadServer(b)
+			f := sparseFile(b, size)
+			b.SetBytes(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					b.Fatal(err)
+				}
+				server.received.Store(0)
+				resp, err := multipartUpload(context.Background(), zap.NewNop(), "1", "archive.tar", bc.reader(f), size, partSize, multipartOptions{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if resp.Size != size {
+					b.Fatalf("server received %d bytes, want %d", resp.Size, size)
+				}
+			}
+		})
+	}
+}
+
+func TestMultipartUploadParts(t *testing.T) {
+	server := newUploadServer(t)
+	const size = 10<<20 + 123
+	f := sparseFile(t, size)
+
+	resp, err := multipartUpload(context.Background(), zap.NewNop(), "1", "archive.tar", f, size, 4<<20, multipartOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.NodeID != "MA_test" {
+		t.Errorf("NodeID = %q, want MA_test", resp.NodeID)
+	}
+	if got := server.received.Load(); got != size {
+		t.Errorf("server received %d bytes, want %d", got, size)
+	}
+}
+
+func TestMultipartUploadHash(t *testing.T) {
+	newUploadServer(t)
+	const size = 10<<20 + 123
+	f := sparseFile(t, size)
+	want := sha256.Sum256(make([]byte, size))
+
+	for _, tc := range []struct {
+		name   string
+		reader func(f *os.File) io.Reader
+	}{
+		{"file", func(f *os.File) io.Reader { return f }},
+		{"stream", func(f *os.File) io.Reader { return struct{ io.Reader }{f} }},
+	} {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := newPartHash(sha256.New())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := multipartUpload(context.Background(), zap.NewNop(), "1", "archive.tar", tc.reader(f), size, 4<<20, multipartOptions{hash: hash}); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := hash.hash.Sum(nil); !bytes.Equal(got, want[:]) {
+			t.Errorf("%s: hash = %x, want %x", tc.name, got, want)
+		}
+	}
+}
+
+// TestMultipartUploadMemory checks that the memory allocated by an upload
+// does not grow with the part size: a file is streamed part by part without
+// a buffer, and a stream needs a single buffer of one part.
+func TestMultipartUploadMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("uploads 256 MiB through a local server")
+	}
+	newUploadServer(t)
+	const size = 256 << 20
+	const partSize = 16 << 20
+	const parts = size / partSize
+	// Requests, responses and logging of a part, far below its size
+	const perPart = 256 << 10
+
+	for _, tc := range []struct {
+		name   string
+		reader func(f *os.File) io.Reader
+		limit  uint64
+	}{
+		{"file", func(f *os.File) io.Reader { return f }, parts * perPart},
+		{"stream", func(f *os.File) io.Reader { return struct{ io.Reader }{f} }, partSize + parts*perPart},
+	} {
+		f := sparseFile(t, size)
+		reader := tc.reader(f)
+
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		if _, err := multipartUpload(context.Background(), zap.NewNop(), "1", "archive.tar", reader, size, partSize, multipartOptions{}); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		runtime.ReadMemStats(&after)
+
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > tc.limit {
+			t.Errorf("%s: uploading %d parts of %d bytes allocated %d bytes, want at most %d", tc.name, parts, partSize, allocated, tc.limit)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package inventory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/github"
+)
+
+// DefaultMaxAge is how old an org inventory may get before it is reported as
+// stale.
+const DefaultMaxAge = 24 * time.Hour
+
+// lockTimeout is how long Update waits for another process to finish with
+// the inventory. A lock older than staleLockAge was left by a process that
+// died holding it and is taken over.
+const (
+	lockTimeout  = 30 * time.Second
+	staleLockAge = 2 * time.Minute
+)
+
+// clockSkew is subtracted from the time of the last sync when picking the
+// archives created since, as it is taken from the local clock and createdAt
+// from GitHub's.
+const clockSkew = 5 * time.Minute
+
+// OrgInventory is the cached list of migration archives for one org on one
+// host. Checksums maps the SHA-256
+// of archives uploaded from this machine to their node IDs, and Migrations
+// maps the IDs of migrations started from this machine to the archive URIs
+// they read from, which the API does not report.
+type OrgInventory struct {
+	Host       string                    `json:"host"`
+	Org        string                    `json:"org"`
+	Archives   []github.MigrationArchive `json:"archives"`
+	Checksums  map[string]string         `json:"checksums,omitempty"`
+	Migrations map[string][]string       `json:"migrations,omitempty"`
+	LastSynced time.Time                 `json:"last_synced,omitempty"`
+}
+
+// Store is a single JSON file holding the inventories of all orgs.
+type Store struct {
+	Path string                   `json:"-"`
+	Orgs map[string]*OrgInventory `json:"orgs"`
+}
+
+// DefaultPath returns $XDG_CACHE_HOME/gh-blob/inventory.json, falling back to
+// the user cache directory.
+func DefaultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine cache directory: %v", err)
+	}
+	return filepath.Join(dir, "gh-blob", "inventory.json"), nil
+}
+
+// Open loads the store at path. A missing file yields an empty store.
+func Open(path string) (*Store, error) {
+	store := &Store{Path: path, Orgs: map[string]*OrgInventory{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory %s: %v", path, err)
+	}
+	if store.Orgs == nil {
+		store.Orgs = map[string]*OrgInventory{}
+	}
+	return store, nil
+}
+
+// OpenDefault opens the store at DefaultPath.
+func OpenDefault() (*Store, error) {
+	path, err := DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return Open(path)
+}
+
+// Update applies fn to the store at path and saves it, holding a lock file
+// next to it so that updates from other processes are not lost. Nothing is
+// saved if fn fails.
+func Update(path string, fn func(s *Store) error) error {
+	unlock, err := lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	store, err := Open(path)
+	if err != nil {
+		return err
+	}
+	if err := fn(store); err != nil {
+		return err
+	}
+	return store.Save()
+}
+
+// UpdateDefault updates the store at DefaultPath.
+func UpdateDefault(fn func(s *Store) error) error {
+	path, err := DefaultPath()
+	if err != nil {
+		return err
+	}
+	return Update(path, fn)
+}
+
+// lock creates path.lock, waiting for lockTimeout while another process
+// holds it, and returns a function removing it.
+func lock(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create inventory directory: %v", err)
+	}
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock inventory: %v", err)
+		}
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for another gh blob to release %s", lockPath)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Save writes the store back to disk atomically, through a temporary file
+// renamed over it.
+func (s *Store) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode inventory: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to create inventory directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write inventory: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write inventory: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write inventory: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.Path); err != nil {
+		return fmt.Errorf("failed to write inventory: %v", err)
+	}
+	return nil
+}
+
+func key(host, org string) string {
+	return host + "/" + org
+}
+
+// Get returns the inventory for org on host, or nil if there is none.
+func (s *Store) Get(host, org string) *OrgInventory {
+	return s.Orgs[key(host, org)]
+}
+
+// List returns every org inventory sorted by host and org.
+func (s *Store) List() []*OrgInventory {
+	keys := make([]string, 0, len(s.Orgs))
+	for k := range s.Orgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	inventories := make([]*OrgInventory, 0, len(keys))
+	for _, k := range keys {
+		inventories = append(inventories, s.Orgs[k])
+	}
+	return inventories
+}
+
+func (s *Store) getOrCreate(host, org string) *OrgInventory {
+	inv, ok := s.Orgs[key(host, org)]
+	if !ok {
+		inv = &OrgInventory{Host: host, Org: org}
+		s.Orgs[key(host, org)] = inv
+	}
+	return inv
+}
+
+// Add records an archive for org, replacing any entry with the same ID.
+func (s *Store) Add(host, org string, archive github.MigrationArchive) {
+	inv := s.getOrCreate(host, org)
+	inv.removeArchive(archive.ID)
+	inv.Archives = append(inv.Archives, archive)
+}
+
+// SetChecksum records that the archive with the given node ID has the given
+// SHA-256 checksum.
+func (s *Store) SetChecksum(host, org, checksum, id string) {
+	inv := s.getOrCreate(host, org)
+	if inv.Checksums == nil {
+		inv.Checksums = map[string]string{}
+	}
+	inv.Checksums[checksum] = id
+}
+
+// FindByChecksum returns the node ID of the archive of org recorded with the
+// given SHA-256 checksum.
+func (s *Store) FindByChecksum(host, org, checksum string) (string, bool) {
+	inv := s.Get(host, org)
+	if inv == nil {
+		return "", false
+	}
+	id, ok := inv.Checksums[checksum]
+	return id, ok
+}
+
+// SetMigrationArchives records the archive URIs a migration of org was
+// started with.
+func (s *Store) SetMigrationArchives(host, org, migrationID string, uris []string) {
+	inv := s.getOrCreate(host, org)
+	if inv.Migrations == nil {
+		inv.Migrations = map[string][]string{}
+	}
+	inv.Migrations[migrationID] = uris
+}
+
+// MigrationArchives returns the archive URIs recorded for the migrations of
+// org, keyed by migration ID.
+func (s *Store) MigrationArchives(host, org string) map[string][]string {
+	inv := s.Get(host, org)
+	if inv == nil {
+		return nil
+	}
+	return inv.Migrations
+}
+
+// FindByID returns the inventory holding the archive with the given node ID.
+func (s *Store) FindByID(id string) (*OrgInventory, bool) {
+	for _, inv := range s.Orgs {
+		for _, archive := range inv.Archives {
+			if archive.ID == id {
+				return inv, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// RemoveByID drops the archive with the given node ID from every org. It
+// reports whether anything was removed.
+func (s *Store) RemoveByID(id string) bool {
+	removed := false
+	for _, inv := range s.Orgs {
+		if inv.Remove(id) {
+			removed = true
+		}
+	}
+	return removed
+}
+
+// Remove drops the archive with the given node ID, and any checksum pointing
+// at it, and reports whether the archive was present.
+func (inv *OrgInventory) Remove(id string) bool {
+	for checksum, checksumID := range inv.Checksums {
+		if checksumID == id {
+			delete(inv.Checksums, checksum)
+		}
+	}
+	return inv.removeArchive(id)
+}
+
+func (inv *OrgInventory) removeArchive(id string) bool {
+	for i, archive := range inv.Archives {
+		if archive.ID == id {
+			inv.Archives = append(inv.Archives[:i], inv.Archives[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Age returns how long ago the inventory was last synced with GitHub.
+func (inv *OrgInventory) Age() time.Duration {
+	if inv.LastSynced.IsZero() {
+		return 0
+	}
+	return time.Since(inv.LastSynced)
+}
+
+// Stale reports whether the inventory was never synced or is older than
+// maxAge.
+func (inv *OrgInventory) Stale(maxAge time.Duration) bool {
+	return inv.LastSynced.IsZero() || inv.Age() > maxAge
+}
+
+// Merge updates the inventory of org with archives, the list GitHub returned
+// at listedAt. A full merge (and the first one) replaces the archives, which
+// is the only way to drop archives deleted other than through gh-blob on
+// this machine. Otherwise the archives already known are kept and those
+// created since the last sync are added. It returns the number of archives
+// added.
+func (s *Store) Merge(host, org string, archives []github.MigrationArchive, listedAt time.Time, full bool) int {
+	inv := s.getOrCreate(host, org)
+	if inv.LastSynced.IsZero() {
+		full = true
+	}
+
+	known := map[string]bool{}
+	for _, archive := range inv.Archives {
+		known[archive.ID] = true
+	}
+	added := 0
+	if full {
+		for _, archive := range archives {
+			if !known[archive.ID] {
+				added++
+			}
+		}
+		inv.Archives = archives
+		inv.pruneChecksums()
+	} else {
+		since := inv.LastSynced.Add(-clockSkew)
+		for _, archive := range archives {
+			if known[archive.ID] || !createdAfter(archive, since) {
+				continue
+			}
+			inv.Archives = append(inv.Archives, archive)
+			added++
+		}
+	}
+
+	sort.SliceStable(inv.Archives, func(i, j int) bool {
+		return inv.Archives[i].CreatedAt < inv.Archives[j].CreatedAt
+	})
+	inv.LastSynced = listedAt.UTC()
+	return added
+}
+
+// createdAfter reports whether archive was created after t. An archive whose
+// creation time cannot be parsed counts as new.
+func createdAfter(archive github.MigrationArchive, t time.Time) bool {
+	created, err := time.Parse(time.RFC3339, archive.CreatedAt)
+	return err != nil || created.After(t)
+}
+
+// pruneChecksums drops checksums of archives no longer in the inventory.
+func (inv *OrgInventory) pruneChecksums() {
+	present := map[string]bool{}
+	for _, archive := range inv.Archives {
+		present[archive.ID] = true
+	}
+	for checksum, id := range inv.Checksums {
+		if !present[id] {
+			delete(inv.Checksums, checksum)
+		}
+	}
+}
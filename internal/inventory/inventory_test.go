@@ -0,0 +1,64 @@
+package inventory
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/github"
+)
+
+func TestUpdateKeepsConcurrentChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.json")
+	const writers = 20
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := Update(path, func(s *Store) error {
+				s.Add("github.com", "org", github.MigrationArchive{ID: fmt.Sprintf("MA_%d", i)})
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(store.Get("github.com", "org").Archives); got != writers {
+		t.Errorf("inventory has %d archives, want %d", got, writers)
+	}
+}
+
+func TestMergeAddsArchivesCreatedSinceLastSync(t *testing.T) {
+	lastSync := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	store := &Store{Orgs: map[string]*OrgInventory{}}
+	store.Merge("github.com", "org", []github.MigrationArchive{
+		{ID: "MA_old", CreatedAt: "2024-03-01T10:00:00Z"},
+	}, lastSync, false)
+
+	listed := []github.MigrationArchive{
+		{ID: "MA_old", CreatedAt: "2024-03-01T10:00:00Z"},
+		// Created before the last sync and deleted locally since
+		{ID: "MA_gone", CreatedAt: "2024-03-01T11:00:00Z"},
+		{ID: "MA_new", CreatedAt: "2024-03-01T13:00:00Z"},
+	}
+	if added := store.Merge("github.com", "org", listed, lastSync.Add(2*time.Hour), false); added != 1 {
+		t.Errorf("incremental merge added %d archives, want 1", added)
+	}
+	if added := store.Merge("github.com", "org", listed, lastSync.Add(3*time.Hour), true); added != 1 {
+		t.Errorf("full merge added %d archives, want 1", added)
+	}
+	if got := len(store.Get("github.com", "org").Archives); got != 3 {
+		t.Errorf("inventory has %d archives after a full merge, want 3", got)
+	}
+}
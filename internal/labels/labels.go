@@ -0,0 +1,173 @@
+package labels
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/config"
+)
+
+// Entry holds the labels of one blob.
+type Entry struct {
+	Labels    map[string]string `json:"labels"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// Store is the JSON file of labels keyed by blob GUID. GitHub keeps no
+// metadata for migration archives beyond their name and size, so labels only
+// exist here and wherever the store is synced to.
+type Store struct {
+	Path  string           `json:"-"`
+	Blobs map[string]Entry `json:"blobs"`
+}
+
+// DefaultPath returns labels.json in the gh-blob state directory.
+func DefaultPath() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "labels.json"), nil
+}
+
+// Open loads the store at path. A missing file yields an empty store.
+func Open(path string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Store{Path: path, Blobs: map[string]Entry{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read labels %s: %v", path, err)
+	}
+	store, err := Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse labels %s: %v", path, err)
+	}
+	store.Path = path
+	return store, nil
+}
+
+// OpenDefault opens the store at DefaultPath.
+func OpenDefault() (*Store, error) {
+	path, err := DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return Open(path)
+}
+
+// Decode decodes a store from its JSON form, e.g. a copy kept in a gist.
+func Decode(data []byte) (*Store, error) {
+	store := &Store{}
+	if err := json.Unmarshal(data, store); err != nil {
+		return nil, err
+	}
+	if store.Blobs == nil {
+		store.Blobs = map[string]Entry{}
+	}
+	return store, nil
+}
+
+// Marshal encodes the store as indented JSON.
+func (s *Store) Marshal() ([]byte, error) {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode labels: %v", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// Save writes the store back to disk atomically.
+func (s *Store) Save() error {
+	data, err := s.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to create labels directory: %v", err)
+	}
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write labels: %v", err)
+	}
+	if err := os.Rename(tmp, s.Path); err != nil {
+		return fmt.Errorf("failed to write labels: %v", err)
+	}
+	return nil
+}
+
+// Get returns the labels of the blob with the given GUID.
+func (s *Store) Get(guid string) map[string]string {
+	return s.Blobs[guid].Labels
+}
+
+// Set adds labels to the blob with the given GUID, replacing the values of
+// keys it already has.
+func (s *Store) Set(guid string, labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	entry := s.Blobs[guid]
+	if entry.Labels == nil {
+		entry.Labels = map[string]string{}
+	}
+	for key, value := range labels {
+		entry.Labels[key] = value
+	}
+	entry.UpdatedAt = time.Now().UTC()
+	s.Blobs[guid] = entry
+}
+
+// Merge takes every blob from other whose labels changed more recently than
+// here and reports whether anything changed.
+func (s *Store) Merge(other *Store) bool {
+	changed := false
+	for guid, theirs := range other.Blobs {
+		ours, ok := s.Blobs[guid]
+		if !ok || theirs.UpdatedAt.After(ours.UpdatedAt) {
+			s.Blobs[guid] = theirs
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Parse parses key=value pairs into labels.
+func Parse(pairs []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q: must be key=value", pair)
+		}
+		labels[key] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+// Matches reports whether labels has every key and value in selector.
+func Matches(labels map[string]string, selector map[string]string) bool {
+	for key, value := range selector {
+		if got, ok := labels[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Format renders labels as comma separated key=value pairs sorted by key.
+func Format(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,188 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robandpdx/gh-blob/pkg/units"
+	"golang.org/x/time/rate"
+)
+
+// maxChunk bounds how many bytes a single Read passes through the limiter,
+// which is also the limiter's burst.
+const maxChunk = 64 * 1024
+
+// Window limits the rate between two times of day. A window whose end is
+// before its start wraps around midnight.
+type Window struct {
+	Start time.Duration // offset from midnight
+	End   time.Duration
+	Rate  int64 // bytes per second, 0 for unlimited
+}
+
+func (w Window) contains(offset time.Duration) bool {
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// Schedule is a list of windows; the first window containing the current
+// local time sets the rate.
+type Schedule []Window
+
+// ParseSchedule parses comma separated windows such as
+// "09:00-18:00=10MB/s,18:00-09:00=100MB/s". A rate of 0 is unlimited.
+func ParseSchedule(s string) (Schedule, error) {
+	var schedule Schedule
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		span, rateValue, ok := strings.Cut(part, "=")
+		startValue, endValue, ok2 := strings.Cut(span, "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid schedule window %q: must look like 09:00-18:00=10MB/s", part)
+		}
+		start, err := parseTimeOfDay(startValue)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(endValue)
+		if err != nil {
+			return nil, err
+		}
+		bps, err := units.ParseRate(rateValue)
+		if err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, Window{Start: start, End: end, Rate: bps})
+	}
+	return schedule, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: must be HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Limiter is a token bucket shared by every request body it wraps, so
+// concurrent parts together stay under the rate.
+type Limiter struct {
+	base     int64
+	schedule Schedule
+
+	mu      sync.Mutex
+	current int64
+	bucket  *rate.Limiter
+}
+
+// New returns a limiter for bytesPerSecond, overridden by schedule during its
+// windows. It returns nil, which limits nothing, if both are unlimited.
+func New(bytesPerSecond int64, schedule Schedule) *Limiter {
+	if bytesPerSecond <= 0 && len(schedule) == 0 {
+		return nil
+	}
+	l := &Limiter{
+		base:     bytesPerSecond,
+		schedule: schedule,
+		bucket:   rate.NewLimiter(rate.Inf, maxChunk),
+		current:  -1,
+	}
+	l.update(time.Now())
+	return l
+}
+
+// RateAt returns the rate in bytes per second that applies at t, 0 meaning
+// unlimited.
+func (l *Limiter) RateAt(t time.Time) int64 {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	for _, window := range l.schedule {
+		if window.contains(offset) {
+			return window.Rate
+		}
+	}
+	return l.base
+}
+
+// MinRate returns the lowest limited rate in bytes per second the limiter
+// ever applies, or 0 if it never limits.
+func (l *Limiter) MinRate() int64 {
+	if l == nil {
+		return 0
+	}
+	min := l.base
+	for _, window := range l.schedule {
+		if window.Rate > 0 && (min <= 0 || window.Rate < min) {
+			min = window.Rate
+		}
+	}
+	if min < 0 {
+		return 0
+	}
+	return min
+}
+
+func (l *Limiter) update(now time.Time) {
+	bps := l.RateAt(now)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if bps == l.current {
+		return
+	}
+	l.current = bps
+	if bps <= 0 {
+		l.bucket.SetLimitAt(now, rate.Inf)
+	} else {
+		l.bucket.SetLimitAt(now, rate.Limit(bps))
+	}
+}
+
+// Reader returns r with reads throttled by the limiter. A nil limiter
+// returns r unchanged.
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &reader{ctx: ctx, r: r, limiter: l}
+}
+
+// ReadCloser is Reader for an io.ReadCloser.
+func (l *Limiter) ReadCloser(ctx context.Context, r io.ReadCloser) io.ReadCloser {
+	if l == nil {
+		return r
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{l.Reader(ctx, r), r}
+}
+
+type reader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > maxChunk {
+		p = p[:maxChunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.limiter.update(time.Now())
+		if waitErr := r.limiter.bucket.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
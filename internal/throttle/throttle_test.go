@@ -0,0 +1,197 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/robandpdx/gh-blob/pkg/units"
+)
+
+func TestReaderLimitsUploadRate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("measures a real upload over about a second")
+	}
+
+	received := make(chan int64, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		received <- n
+	}))
+	defer server.Close()
+
+	const bps = 1 * units.MiB
+	const size = 3 * bps / 2
+	limiter := New(bps, nil)
+	body := limiter.Reader(context.Background(), bytes.NewReader(make([]byte, size)))
+
+	start := time.Now()
+	req, err := http.NewRequest(http.MethodPost, server.URL, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	elapsed := time.Since(start)
+
+	if n := <-received; n != size {
+		t.Fatalf("server received %d bytes, want %d", n, size)
+	}
+	// The first burst is free, the rest is paced at bps
+	want := time.Duration(float64(size-maxChunk) / float64(bps) * float64(time.Second))
+	if elapsed < want*80/100 || elapsed > want*130/100 {
+		t.Errorf("upload of %d bytes took %v, want about %v", size, elapsed, want)
+	}
+}
+
+func TestReaderCancelled(t *testing.T) {
+	limiter := New(1024, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := io.ReadAll(limiter.Reader(ctx, bytes.NewReader(make([]byte, 4*maxChunk))))
+	if err == nil {
+		t.Fatal("expected an error reading with a cancelled context")
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	if l := New(0, nil); l != nil {
+		t.Fatalf("New(0, nil) = %v, want nil", l)
+	}
+	var l *Limiter
+	r := bytes.NewReader(nil)
+	if got := l.Reader(context.Background(), r); got != r {
+		t.Error("nil limiter wrapped the reader")
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Schedule
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{
+			in: "09:00-18:00=10MB/s",
+			want: Schedule{
+				{Start: 9 * time.Hour, End: 18 * time.Hour, Rate: 10 * units.MB},
+			},
+		},
+		{
+			in: " 09:00-18:30=10MiB/s , 22:00-06:00=0 ,",
+			want: Schedule{
+				{Start: 9 * time.Hour, End: 18*time.Hour + 30*time.Minute, Rate: 10 * units.MiB},
+				{Start: 22 * time.Hour, End: 6 * time.Hour, Rate: 0},
+			},
+		},
+		{in: "09:00=10MB/s", wantErr: true},
+		{in: "09:00-18:00", wantErr: true},
+		{in: "9am-18:00=10MB/s", wantErr: true},
+		{in: "09:00-24:00=10MB/s", wantErr: true},
+		{in: "09:00-18:00=fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSchedule(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseSchedule(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseSchedule(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("ParseSchedule(%q) = %v, want %v", tt.in, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("ParseSchedule(%q)[%d] = %+v, want %+v", tt.in, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestRateAt(t *testing.T) {
+	schedule, err := ParseSchedule("09:00-18:00=10MB/s,22:00-06:00=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(100*units.MB, schedule)
+
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, 3, 1, hour, min, 0, 0, time.Local)
+	}
+	tests := []struct {
+		t    time.Time
+		want int64
+	}{
+		{at(8, 59), 100 * units.MB},
+		{at(9, 0), 10 * units.MB},
+		{at(17, 59), 10 * units.MB},
+		{at(18, 0), 100 * units.MB},
+		{at(21, 59), 100 * units.MB},
+		// The 22:00-06:00 window wraps past midnight
+		{at(22, 0), 0},
+		{at(23, 59), 0},
+		{at(0, 0), 0},
+		{at(5, 59), 0},
+		{at(6, 0), 100 * units.MB},
+	}
+	for _, tt := range tests {
+		if got := l.RateAt(tt.t); got != tt.want {
+			t.Errorf("RateAt(%s) = %d, want %d", tt.t.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestRateAtFirstWindowWins(t *testing.T) {
+	schedule, err := ParseSchedule("00:00-12:00=1MB/s,06:00-18:00=2MB/s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(0, schedule)
+	if got := l.RateAt(time.Date(2024, 3, 1, 7, 0, 0, 0, time.Local)); got != units.MB {
+		t.Errorf("RateAt(07:00) = %d, want %d", got, units.MB)
+	}
+	if got := l.RateAt(time.Date(2024, 3, 1, 20, 0, 0, 0, time.Local)); got != 0 {
+		t.Errorf("RateAt(20:00) = %d, want unlimited", got)
+	}
+}
+
+func TestMinRate(t *testing.T) {
+	schedule, err := ParseSchedule("09:00-18:00=10MB/s,22:00-06:00=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		base     int64
+		schedule Schedule
+		want     int64
+	}{
+		{100 * units.MB, nil, 100 * units.MB},
+		{100 * units.MB, schedule, 10 * units.MB},
+		{0, schedule, 10 * units.MB},
+		{units.MB, schedule, units.MB},
+	}
+	for _, tt := range tests {
+		if got := New(tt.base, tt.schedule).MinRate(); got != tt.want {
+			t.Errorf("MinRate() with base %d = %d, want %d", tt.base, got, tt.want)
+		}
+	}
+	var l *Limiter
+	if got := l.MinRate(); got != 0 {
+		t.Errorf("nil MinRate() = %d, want 0", got)
+	}
+}
@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Options configures TLS for every connection gh-blob makes. Proxies are
+// always taken from HTTPS_PROXY, HTTP_PROXY and NO_PROXY.
+type Options struct {
+	// CABundle is a PEM file of certificates trusted in addition to the
+	// system roots, e.g. the CA of a TLS-inspecting proxy.
+	CABundle string
+	// ClientCert and ClientKey are PEM files of the certificate presented
+	// for mutual TLS. The key defaults to the certificate file.
+	ClientCert string
+	ClientKey  string
+	// InsecureSkipVerify disables certificate verification.
+	InsecureSkipVerify bool
+}
+
+var (
+	mu      sync.RWMutex
+	current http.RoundTripper = newTransport(nil)
+)
+
+// Configure replaces the shared transport with one built from opts.
+func Configure(opts Options) error {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if opts.CABundle != "" {
+		pem, err := os.ReadFile(opts.CABundle)
+		if err != nil {
+			return fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA bundle %s", opts.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.ClientCert != "" {
+		key := opts.ClientKey
+		if key == "" {
+			key = opts.ClientCert
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, key)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else if opts.ClientKey != "" {
+		return fmt.Errorf("a client key requires a client certificate")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	current = newTransport(tlsConfig)
+	return nil
+}
+
+func newTransport(tlsConfig *tls.Config) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = http.ProxyFromEnvironment
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig
+	}
+	return t
+}
+
+// RoundTripper returns the shared transport.
+func RoundTripper() http.RoundTripper {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Client returns an HTTP client using the shared transport.
+func Client() *http.Client {
+	return &http.Client{Transport: RoundTripper()}
+}
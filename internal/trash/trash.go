@@ -0,0 +1,131 @@
+package trash
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/config"
+)
+
+// DefaultRetention is how long a soft deleted blob stays in the trash before
+// purge deletes it.
+const DefaultRetention = 72 * time.Hour
+
+// Entry is a blob that was soft deleted and is waiting to be purged.
+type Entry struct {
+	ID         string    `json:"id"`
+	GUID       string    `json:"guid,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Size       int       `json:"size,omitempty"`
+	URI        string    `json:"uri,omitempty"`
+	Org        string    `json:"org,omitempty"`
+	Host       string    `json:"host,omitempty"`
+	TrashedAt  time.Time `json:"trashed_at"`
+	PurgeAfter time.Time `json:"purge_after"`
+}
+
+// Expired reports whether the entry's grace period is over at now.
+func (e Entry) Expired(now time.Time) bool {
+	return !now.Before(e.PurgeAfter)
+}
+
+// Manifest is the JSON file listing the trashed blobs.
+type Manifest struct {
+	Path    string  `json:"-"`
+	Entries []Entry `json:"entries"`
+}
+
+// DefaultPath returns trash.json in the gh-blob state directory.
+func DefaultPath() (string, error) {
+	dir, err := config.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "trash.json"), nil
+}
+
+// Open loads the manifest at path. A missing file yields an empty manifest.
+func Open(path string) (*Manifest, error) {
+	manifest := &Manifest{Path: path}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trash manifest %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse trash manifest %s: %v", path, err)
+	}
+	return manifest, nil
+}
+
+// OpenDefault opens the manifest at DefaultPath.
+func OpenDefault() (*Manifest, error) {
+	path, err := DefaultPath()
+	if err != nil {
+		return nil, err
+	}
+	return Open(path)
+}
+
+// Save writes the manifest back to disk atomically.
+func (m *Manifest) Save() error {
+	sort.SliceStable(m.Entries, func(i, j int) bool {
+		return m.Entries[i].PurgeAfter.Before(m.Entries[j].PurgeAfter)
+	})
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode trash manifest: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(m.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to create trash directory: %v", err)
+	}
+	tmp := m.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write trash manifest: %v", err)
+	}
+	if err := os.Rename(tmp, m.Path); err != nil {
+		return fmt.Errorf("failed to write trash manifest: %v", err)
+	}
+	return nil
+}
+
+// Find returns the entry for the blob with the given node ID.
+func (m *Manifest) Find(id string) (Entry, bool) {
+	for _, entry := range m.Entries {
+		if entry.ID == id {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Add puts an entry in the trash. Trashing a blob twice is an error so the
+// original purge time is kept.
+func (m *Manifest) Add(entry Entry) error {
+	if existing, ok := m.Find(entry.ID); ok {
+		return fmt.Errorf("blob %s is already in the trash until %s", entry.ID, existing.PurgeAfter.Format(time.RFC3339))
+	}
+	m.Entries = append(m.Entries, entry)
+	return nil
+}
+
+// Remove takes the entry for id out of the trash and reports whether it was
+// there.
+func (m *Manifest) Remove(id string) bool {
+	for i, entry := range m.Entries {
+		if entry.ID == id {
+			m.Entries = append(m.Entries[:i], m.Entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
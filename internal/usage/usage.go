@@ -0,0 +1,284 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/robandpdx/gh-blob/internal/github"
+	"github.com/robandpdx/gh-blob/pkg/units"
+)
+
+// Bucket is one row of a histogram. Cumulative is only set for growth
+// buckets, where it holds the running total of bytes.
+type Bucket struct {
+	Label      string `json:"label"`
+	Count      int    `json:"count"`
+	Bytes      int64  `json:"bytes"`
+	Cumulative int64  `json:"cumulative_bytes,omitempty"`
+}
+
+// OrgUsage summarizes the migration archives of one organization.
+type OrgUsage struct {
+	Org           string                    `json:"org"`
+	Count         int                       `json:"count"`
+	Bytes         int64                     `json:"bytes"`
+	SizeHistogram []Bucket                  `json:"size_histogram"`
+	AgeBuckets    []Bucket                  `json:"age_buckets"`
+	Growth        []Bucket                  `json:"growth"`
+	Largest       []github.MigrationArchive `json:"largest"`
+}
+
+// Report is the usage of one or more organizations plus their total.
+type Report struct {
+	GeneratedAt time.Time  `json:"generated_at"`
+	Orgs        []OrgUsage `json:"orgs"`
+	Total       *OrgUsage  `json:"total,omitempty"`
+}
+
+var sizeBounds = []struct {
+	label string
+	max   int64
+}{
+	{"< 100 MiB", 100 * units.MiB},
+	{"100 MiB - 1 GiB", units.GiB},
+	{"1 GiB - 5 GiB", 5 * units.GiB},
+	{"5 GiB - 10 GiB", 10 * units.GiB},
+	{">= 10 GiB", -1},
+}
+
+var ageBounds = []struct {
+	label string
+	max   time.Duration
+}{
+	{"< 1 day", 24 * time.Hour},
+	{"1 - 7 days", 7 * 24 * time.Hour},
+	{"7 - 30 days", 30 * 24 * time.Hour},
+	{"30 - 90 days", 90 * 24 * time.Hour},
+	{">= 90 days", -1},
+}
+
+// Summarize aggregates archives into an OrgUsage, keeping the top largest
+// archives.
+func Summarize(org string, archives []github.MigrationArchive, now time.Time, top int) OrgUsage {
+	u := OrgUsage{
+		Org:           org,
+		SizeHistogram: make([]Bucket, len(sizeBounds)),
+		AgeBuckets:    make([]Bucket, len(ageBounds)),
+	}
+	for i, b := range sizeBounds {
+		u.SizeHistogram[i].Label = b.label
+	}
+	for i, b := range ageBounds {
+		u.AgeBuckets[i].Label = b.label
+	}
+
+	growth := map[string]*Bucket{}
+	for _, archive := range archives {
+		size := int64(archive.Size)
+		u.Count++
+		u.Bytes += size
+
+		for i, b := range sizeBounds {
+			if b.max < 0 || size < b.max {
+				u.SizeHistogram[i].Count++
+				u.SizeHistogram[i].Bytes += size
+				break
+			}
+		}
+
+		createdAt, err := time.Parse(time.RFC3339, archive.CreatedAt)
+		if err != nil {
+			continue
+		}
+		age := now.Sub(createdAt)
+		for i, b := range ageBounds {
+			if b.max < 0 || age < b.max {
+				u.AgeBuckets[i].Count++
+				u.AgeBuckets[i].Bytes += size
+				break
+			}
+		}
+
+		month := createdAt.UTC().Format("2006-01")
+		if growth[month] == nil {
+			growth[month] = &Bucket{Label: month}
+		}
+		growth[month].Count++
+		growth[month].Bytes += size
+	}
+
+	months := make([]string, 0, len(growth))
+	for month := range growth {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	var cumulative int64
+	for _, month := range months {
+		cumulative += growth[month].Bytes
+		growth[month].Cumulative = cumulative
+		u.Growth = append(u.Growth, *growth[month])
+	}
+
+	largest := append([]github.MigrationArchive(nil), archives...)
+	sort.SliceStable(largest, func(i, j int) bool {
+		return largest[i].Size > largest[j].Size
+	})
+	if len(largest) > top {
+		largest = largest[:top]
+	}
+	u.Largest = largest
+
+	return u
+}
+
+// NewReport summarizes each org and, when there is more than one, adds a
+// total across all of them.
+func NewReport(archivesByOrg map[string][]github.MigrationArchive, now time.Time, top int) Report {
+	orgs := make([]string, 0, len(archivesByOrg))
+	for org := range archivesByOrg {
+		orgs = append(orgs, org)
+	}
+	sort.Strings(orgs)
+
+	report := Report{GeneratedAt: now.UTC()}
+	var all []github.MigrationArchive
+	for _, org := range orgs {
+		report.Orgs = append(report.Orgs, Summarize(org, archivesByOrg[org], now, top))
+		all = append(all, archivesByOrg[org]...)
+	}
+	if len(orgs) > 1 {
+		total := Summarize("total", all, now, top)
+		report.Total = &total
+	}
+	return report
+}
+
+// Render writes the report as table, json or markdown.
+func Render(w io.Writer, report Report, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	case "markdown":
+		renderMarkdown(w, report)
+		return nil
+	case "table":
+		return renderTable(w, report)
+	}
+	return fmt.Errorf("invalid output format %q: must be table, json or markdown", format)
+}
+
+func sections(report Report) []OrgUsage {
+	s := report.Orgs
+	if report.Total != nil {
+		s = append(append([]OrgUsage(nil), s...), *report.Total)
+	}
+	return s
+}
+
+func renderTable(w io.Writer, report Report) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, u := range sections(report) {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "%s: %d archives, %s\n\n", u.Org, u.Count, units.FormatBytes(u.Bytes))
+
+		for _, section := range []struct {
+			title   string
+			buckets []Bucket
+		}{
+			{"SIZE", u.SizeHistogram},
+			{"AGE", u.AgeBuckets},
+		} {
+			fmt.Fprintf(tw, "%s\tCOUNT\tBYTES\n", section.title)
+			for _, b := range section.buckets {
+				fmt.Fprintf(tw, "%s\t%d\t%s\n", b.Label, b.Count, units.FormatBytes(b.Bytes))
+			}
+			fmt.Fprintln(tw)
+		}
+
+		fmt.Fprintln(tw, "MONTH\tCOUNT\tBYTES\tTOTAL")
+		for _, b := range u.Growth {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", b.Label, b.Count, units.FormatBytes(b.Bytes), units.FormatBytes(b.Cumulative))
+		}
+		fmt.Fprintln(tw)
+
+		fmt.Fprintln(tw, "LARGEST\tSIZE\tCREATED\tID")
+		for _, a := range u.Largest {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", a.Name, units.FormatBytes(int64(a.Size)), a.CreatedAt, a.ID)
+		}
+	}
+	return tw.Flush()
+}
+
+func renderMarkdown(w io.Writer, report Report) {
+	row := func(cells ...string) {
+		escaped := make([]string, len(cells))
+		for i, cell := range cells {
+			escaped[i] = markdownCell(cell)
+		}
+		fmt.Fprintf(w, "| %s |\n", strings.Join(escaped, " | "))
+	}
+	header := func(cells ...string) {
+		row(cells...)
+		sep := make([]string, len(cells))
+		for i := range sep {
+			sep[i] = "---"
+		}
+		row(sep...)
+	}
+
+	for i, u := range sections(report) {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "### %s\n\n", u.Org)
+		fmt.Fprintf(w, "**%d** archives, **%s**\n\n", u.Count, units.FormatBytes(u.Bytes))
+
+		header("Size", "Count", "Bytes")
+		for _, b := range u.SizeHistogram {
+			row(b.Label, fmt.Sprint(b.Count), units.FormatBytes(b.Bytes))
+		}
+		fmt.Fprintln(w)
+
+		header("Age", "Count", "Bytes")
+		for _, b := range u.AgeBuckets {
+			row(b.Label, fmt.Sprint(b.Count), units.FormatBytes(b.Bytes))
+		}
+		fmt.Fprintln(w)
+
+		header("Month", "Count", "Bytes", "Total")
+		for _, b := range u.Growth {
+			row(b.Label, fmt.Sprint(b.Count), units.FormatBytes(b.Bytes), units.FormatBytes(b.Cumulative))
+		}
+		fmt.Fprintln(w)
+
+		header("Largest", "Size", "Created", "ID")
+		for _, a := range u.Largest {
+			row("`"+a.Name+"`", units.FormatBytes(int64(a.Size)), a.CreatedAt, "`"+a.ID+"`")
+		}
+	}
+}
+
+// markdownCellReplacer escapes the characters that would end a table cell or
+// row. Archive names are chosen by whoever uploaded them, so they can hold
+// anything.
+var markdownCellReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	"|", `\|`,
+	"\r\n", "<br>",
+	"\n", "<br>",
+	"\r", "<br>",
+)
+
+// markdownCell returns s escaped for use in a markdown table cell.
+func markdownCell(s string) string {
+	return markdownCellReplacer.Replace(s)
+}
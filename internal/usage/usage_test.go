@@ -0,0 +1,19 @@
+package usage
+
+import "testing"
+
+func TestMarkdownCell(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"archive.tar", "archive.tar"},
+		{"a|b.tar", `a\|b.tar`},
+		{"line\nbreak\r\nand\rmore", "line<br>break<br>and<br>more"},
+		{`back\slash|`, `back\\slash\|`},
+	}
+	for _, tt := range tests {
+		if got := markdownCell(tt.in); got != tt.want {
+			t.Errorf("markdownCell(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/robandpdx/gh-blob/cmd"
 	"github.com/robandpdx/gh-blob/pkg/logger"
-	"go.uber.org/zap"
 
 	"github.com/spf13/cobra"
 )
@@ -15,44 +17,73 @@ func main() {
 	var rootCmd = &cobra.Command{
 		Use:   "gh blob",
 		Short: "GitHub GitLab Migration Tool",
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
+			if err := cmd.ApplyProfile(c); err != nil {
+				return err
+			}
+			if err := cmd.ConfigureLogger(c); err != nil {
+				return err
+			}
+			if err := cmd.RequireToken(c); err != nil {
+				return err
+			}
+			return cmd.ConfigureTransport(c)
+		},
 	}
 
+	rootCmd.PersistentFlags().String("profile", "", "Config profile to use (defaults to $GH_BLOB_PROFILE or the configured default)")
+	rootCmd.PersistentFlags().String("log-level", "info", "Log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().String("log-format", "console", "Log format: console, json or logfmt")
+	rootCmd.PersistentFlags().String("log-file", "", "Append logs to this file instead of stderr")
+	rootCmd.PersistentFlags().String("ca-bundle", "", "PEM file of extra CA certificates to trust, e.g. for a TLS-inspecting proxy")
+	rootCmd.PersistentFlags().String("client-cert", "", "PEM client certificate for mutual TLS")
+	rootCmd.PersistentFlags().String("client-key", "", "PEM private key of --client-cert (defaults to the certificate file)")
+	rootCmd.PersistentFlags().Bool("insecure-skip-verify", false, "Disable TLS certificate verification (unsafe)")
+	rootCmd.PersistentFlags().String("audit-log", "", "Audit log file or http(s) endpoint (defaults to ~/.local/state/gh-blob/audit.jsonl)")
+
 	// Add commands
 	rootCmd.AddCommand(
 		cmd.UploadBlob(),
 		cmd.QueryAllBlobs(),
 		cmd.QueryBlob(),
 		cmd.DeleteBlob(),
+		cmd.ConfigCmd(),
+		cmd.AuditCmd(),
+		cmd.InventoryCmd(),
+		cmd.Usage(),
+		cmd.Migrate(),
+		cmd.MigrationStatus(),
+		cmd.TrashCmd(),
+		cmd.Validate(),
+		cmd.Pack(),
+		cmd.LabelsCmd(),
 	)
 
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println("Error:", err)
+	if err := rootCmd.ExecuteContext(signalContext()); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		os.Exit(1)
 	}
 }
 
+// signalContext returns a context cancelled on the first SIGINT or SIGTERM,
+// which lets in-flight uploads finish their current part and stop. A second
+// signal exits immediately.
+func signalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		logger.Logger.Warn("Interrupted, stopping after in-flight requests finish. Press Ctrl-C again to exit immediately")
+		cancel()
+		<-signals
+		logger.Logger.Error("Interrupted again, exiting immediately")
+		logger.SyncLogger()
+		os.Exit(130)
+	}()
+	return ctx
+}
+
 func init() {
 	logger.InitLogger()
-	defer logger.SyncLogger()
-
-	required := []struct {
-		name  string
-		value string
-	}{
-		{"GITHUB_TOKEN", os.Getenv("GITHUB_TOKEN")},
-	}
-
-	var missing []string
-
-	for _, r := range required {
-		if r.value == "" {
-			missing = append(missing, r.name)
-		}
-	}
-
-	if len(missing) > 0 {
-		logger.Logger.Error("Missing required environment variables",
-			zap.Strings("missing", missing))
-		os.Exit(1)
-	}
 }
@@ -0,0 +1,82 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+var logfmtPool = buffer.NewPool()
+
+// logfmtEncoder writes entries as space separated key=value pairs. Fields are
+// collected with a MapObjectEncoder and rendered in sorted order after the
+// time, level and message keys.
+type logfmtEncoder struct {
+	*zapcore.MapObjectEncoder
+	cfg zapcore.EncoderConfig
+}
+
+func newLogfmtEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	return &logfmtEncoder{
+		MapObjectEncoder: zapcore.NewMapObjectEncoder(),
+		cfg:              cfg,
+	}
+}
+
+func (e *logfmtEncoder) Clone() zapcore.Encoder {
+	clone := &logfmtEncoder{
+		MapObjectEncoder: zapcore.NewMapObjectEncoder(),
+		cfg:              e.cfg,
+	}
+	for k, v := range e.Fields {
+		clone.Fields[k] = v
+	}
+	return clone
+}
+
+func (e *logfmtEncoder) EncodeEntry(entry zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	final := e.Clone().(*logfmtEncoder)
+	for _, f := range fields {
+		f.AddTo(final)
+	}
+
+	buf := logfmtPool.Get()
+	writePair(buf, e.cfg.TimeKey, entry.Time.Format(time.RFC3339))
+	writePair(buf, e.cfg.LevelKey, entry.Level.String())
+	if entry.LoggerName != "" {
+		writePair(buf, e.cfg.NameKey, entry.LoggerName)
+	}
+	writePair(buf, e.cfg.MessageKey, entry.Message)
+
+	keys := make([]string, 0, len(final.Fields))
+	for k := range final.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writePair(buf, k, fmt.Sprint(final.Fields[k]))
+	}
+
+	if entry.Stack != "" {
+		writePair(buf, e.cfg.StacktraceKey, entry.Stack)
+	}
+	buf.AppendString(e.cfg.LineEnding)
+	return buf, nil
+}
+
+func writePair(buf *buffer.Buffer, key, value string) {
+	if buf.Len() > 0 {
+		buf.AppendByte(' ')
+	}
+	buf.AppendString(key)
+	buf.AppendByte('=')
+	if value == "" || strings.ContainsAny(value, " =\"\t\r\n") {
+		buf.AppendString(fmt.Sprintf("%q", value))
+		return
+	}
+	buf.AppendString(value)
+}
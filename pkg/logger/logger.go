@@ -1,17 +1,85 @@
 package logger
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/term"
 )
 
 var Logger *zap.Logger
 
+// useColor controls whether the console encoder emits ANSI color codes. It is
+// only enabled when the log destination is a terminal and NO_COLOR is unset.
+var useColor bool
+
+var logFile *os.File
+
+// Options configures the logger from the command line.
+type Options struct {
+	Level  string // debug, info, warn or error
+	Format string // console, json or logfmt
+	File   string // path to append logs to, stderr when empty
+}
+
+// InitLogger installs a console logger on stderr at info level. It is
+// replaced by Configure once the command line has been parsed.
 func InitLogger() {
-	config := zapcore.EncoderConfig{
+	if err := Configure(Options{}); err != nil {
+		panic(err)
+	}
+}
+
+// Configure (re)builds Logger from opts.
+func Configure(opts Options) error {
+	level := zapcore.InfoLevel
+	if opts.Level != "" {
+		if err := level.UnmarshalText([]byte(strings.ToLower(opts.Level))); err != nil {
+			return fmt.Errorf("invalid log level %q: %v", opts.Level, err)
+		}
+	}
+
+	var sink *os.File = os.Stderr
+	if opts.File != "" {
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %v", err)
+		}
+		if logFile != nil {
+			_ = logFile.Close()
+		}
+		logFile = f
+		sink = f
+	}
+
+	_, noColor := os.LookupEnv("NO_COLOR")
+	useColor = !noColor && term.IsTerminal(int(sink.Fd()))
+
+	var encoder zapcore.Encoder
+	switch strings.ToLower(opts.Format) {
+	case "", "console":
+		encoder = zapcore.NewConsoleEncoder(consoleEncoderConfig())
+	case "json":
+		encoder = zapcore.NewJSONEncoder(structuredEncoderConfig())
+	case "logfmt":
+		encoder = newLogfmtEncoder(structuredEncoderConfig())
+	default:
+		return fmt.Errorf("invalid log format %q: must be console, json or logfmt", opts.Format)
+	}
+
+	core := zapcore.NewCore(encoder, zapcore.Lock(sink), level)
+
+	// Only add caller for debug level
+	Logger = zap.New(core, zap.AddCallerSkip(1))
+	return nil
+}
+
+func consoleEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
 		TimeKey:        "time",
 		LevelKey:       "level",
 		MessageKey:     "msg",
@@ -25,15 +93,25 @@ func InitLogger() {
 		EncodeCaller:   customCallerEncoder,
 		EncodeName:     zapcore.FullNameEncoder,
 	}
+}
 
-	core := zapcore.NewCore(
-		zapcore.NewConsoleEncoder(config),
-		zapcore.AddSync(os.Stdout),
-		zapcore.DebugLevel, // Changed to show debug logs
-	)
-
-	// Only add caller for debug level
-	Logger = zap.New(core, zap.AddCallerSkip(1))
+// structuredEncoderConfig is used for machine readable output, so it never
+// colors and uses RFC 3339 timestamps.
+func structuredEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:        "time",
+		LevelKey:       "level",
+		MessageKey:     "msg",
+		CallerKey:      "caller",
+		NameKey:        "logger",
+		StacktraceKey:  "stacktrace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.RFC3339TimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+		EncodeName:     zapcore.FullNameEncoder,
+	}
 }
 
 const (
@@ -43,14 +121,19 @@ const (
 	colorYellow = "\033[33m"
 	colorBlue   = "\033[34m"
 	colorCyan   = "\033[36m"
+	colorDim    = "\033[2m"
 )
 
+func colorize(color, s string) string {
+	if !useColor {
+		return s
+	}
+	return color + s + colorReset
+}
+
 func customTimeEncoder(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 	// Add blue color to timestamp with brackets
-	const colorBlue = "\033[34m"
-	const colorReset = "\033[0m"
-	timeStr := colorBlue + "[" + t.Format("2006-01-02 15:04:05") + "]" + colorReset
-	enc.AppendString(timeStr)
+	enc.AppendString(colorize(colorBlue, "["+t.Format("2006-01-02 15:04:05")+"]"))
 }
 
 func SyncLogger() {
@@ -58,21 +141,17 @@ func SyncLogger() {
 }
 
 func customLevelEncoder(level zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
-	// Color codes
-
 	// Add colors based on log level
-	var levelStr string
+	levelStr := "[" + level.CapitalString() + "]"
 	switch level {
 	case zapcore.InfoLevel:
-		levelStr = colorBlue + "[" + level.CapitalString() + "]" + colorReset
+		levelStr = colorize(colorBlue, levelStr)
 	case zapcore.WarnLevel:
-		levelStr = colorYellow + "[" + level.CapitalString() + "]" + colorReset
+		levelStr = colorize(colorYellow, levelStr)
 	case zapcore.ErrorLevel:
-		levelStr = colorRed + "[" + level.CapitalString() + "]" + colorReset
+		levelStr = colorize(colorRed, levelStr)
 	case zapcore.DebugLevel:
-		levelStr = colorGreen + "[" + level.CapitalString() + "]" + colorReset
-	default:
-		levelStr = "[" + level.CapitalString() + "]"
+		levelStr = colorize(colorGreen, levelStr)
 	}
 
 	enc.AppendString(levelStr)
@@ -81,10 +160,7 @@ func customLevelEncoder(level zapcore.Level, enc zapcore.PrimitiveArrayEncoder)
 func customCallerEncoder(caller zapcore.EntryCaller, enc zapcore.PrimitiveArrayEncoder) {
 	// Only show caller for debug level logs
 	if Logger.Core().Enabled(zapcore.DebugLevel) {
-		const colorDim = "\033[2m"
-		const colorReset = "\033[0m"
-		callerStr := colorDim + padRight(caller.TrimmedPath(), 30) + colorReset
-		enc.AppendString(callerStr)
+		enc.AppendString(colorize(colorDim, padRight(caller.TrimmedPath(), 30)))
 	}
 }
 
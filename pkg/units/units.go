@@ -0,0 +1,96 @@
+package units
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	KB int64 = 1000
+	MB       = 1000 * KB
+	GB       = 1000 * MB
+	TB       = 1000 * GB
+
+	KiB int64 = 1024
+	MiB       = 1024 * KiB
+	GiB       = 1024 * MiB
+	TiB       = 1024 * GiB
+)
+
+var suffixes = map[string]int64{
+	"":    1,
+	"b":   1,
+	"k":   KiB,
+	"kb":  KB,
+	"kib": KiB,
+	"m":   MiB,
+	"mb":  MB,
+	"mib": MiB,
+	"g":   GiB,
+	"gb":  GB,
+	"gib": GiB,
+	"t":   TiB,
+	"tb":  TB,
+	"tib": TiB,
+}
+
+// ParseBytes parses a human readable size such as "100MiB", "5GB" or "1048576"
+// into a number of bytes. Bare k/m/g/t suffixes are treated as binary units.
+func ParseBytes(s string) (int64, error) {
+	str := strings.ToLower(strings.TrimSpace(s))
+	if str == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	i := 0
+	for i < len(str) && (str[i] >= '0' && str[i] <= '9' || str[i] == '.') {
+		i++
+	}
+	number, suffix := str[:i], strings.TrimSpace(str[i:])
+
+	multiplier, ok := suffixes[suffix]
+	if !ok {
+		return 0, fmt.Errorf("invalid size unit %q in %q", suffix, s)
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %v", s, err)
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("size must not be negative: %q", s)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
+
+// FormatBytes renders a byte count using binary units, e.g. "1.5 GiB".
+func FormatBytes(n int64) string {
+	units := []struct {
+		size int64
+		name string
+	}{
+		{TiB, "TiB"},
+		{GiB, "GiB"},
+		{MiB, "MiB"},
+		{KiB, "KiB"},
+	}
+	for _, u := range units {
+		if n >= u.size {
+			return fmt.Sprintf("%.1f %s", float64(n)/float64(u.size), u.name)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// ParseRate parses a transfer rate in bytes such as "50MB/s" or "10MiB" into
+// bytes per second. The /s suffix is optional.
+func ParseRate(s string) (int64, error) {
+	str := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "/s")
+	rate, err := ParseBytes(str)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %v", s, err)
+	}
+	return rate, nil
+}